@@ -15,6 +15,11 @@ import (
 // demon 启动服务
 func demon(c *core.Core) {
 
+	// 恢复异常中断的安装，完成后再对外提供服务
+	if err := c.Recover(); err != nil {
+		log.Printf("recover interrupted update: %v", err)
+	}
+
 	g, ctx := errgroup.WithContext(context.Background())
 
 	// 初始化unix socket
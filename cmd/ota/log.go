@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ruixiaoedu/ota/unixsocket/pb"
+	"golang.org/x/net/context"
+	"log"
+)
+
+// showLog 显示升级的脚本输出
+func showLog() {
+	conn := dial()
+	defer conn.Close()
+
+	client := pb.NewOtaClient(conn)
+
+	var id string
+	if logIdArg != nil {
+		id = *logIdArg
+	}
+
+	logReply, err := client.Log(context.Background(), &pb.LogRequest{Id: id})
+	if err != nil {
+		log.Fatalln("Log fail: " + err.Error())
+		return
+	} else if !logReply.Ok {
+		log.Fatalln("Log fail: " + logReply.Message)
+		return
+	}
+
+	fmt.Print(logReply.Content)
+}
@@ -15,10 +15,25 @@ var (
 
 	demonCommand = app.Command("demon", "start with demon program")
 
-	updateCommand  = app.Command("update", "run the update program")
-	standAloneFlag = updateCommand.Flag("stand-alone", "update without use daemon mode").Bool()
-	updateUrlFlag  = updateCommand.Flag("url", "update with web url").Short('u').String()
-	updateFileFlag = updateCommand.Flag("file", "update with local file").Short('f').String()
+	updateCommand   = app.Command("update", "run the update program")
+	standAloneFlag  = updateCommand.Flag("stand-alone", "update without use daemon mode").Bool()
+	updateUrlFlag   = updateCommand.Flag("url", "update with web url").Short('u').String()
+	updateFileFlag  = updateCommand.Flag("file", "update with local file").Short('f').String()
+	updateRootFlag  = updateCommand.Flag("root", "install into the given root directory").String()
+	updateForceFlag = updateCommand.Flag("force", "take over paths owned by other packages").Bool()
+
+	removeCommand  = app.Command("remove", "uninstall a package")
+	removeNameArg  = removeCommand.Arg("name", "the name of the package").Required().String()
+	removeRootFlag = removeCommand.Flag("root", "uninstall from the given root directory").String()
+
+	verifyCommand    = app.Command("verify", "check installed files against the package database")
+	verifyNameArg    = verifyCommand.Arg("name", "the name of the package, default all packages").String()
+	verifyRootFlag   = verifyCommand.Flag("root", "verify packages in the given root directory").String()
+	verifyRepairFlag = verifyCommand.Flag("repair", "restore damaged files from the cache").Bool()
+	verifyJsonFlag   = verifyCommand.Flag("json", "print the results as json").Bool()
+
+	logCommand = app.Command("log", "show the script output of an update")
+	logIdArg   = logCommand.Arg("id", "the id of the update, default the latest").String()
 )
 
 func main() {
@@ -43,5 +58,11 @@ func main() {
 		demon(c)
 	case updateCommand.FullCommand(): // 升级
 		update(c)
+	case removeCommand.FullCommand(): // 卸载
+		remove()
+	case verifyCommand.FullCommand(): // 校验
+		verify()
+	case logCommand.FullCommand(): // 脚本输出
+		showLog()
 	}
 }
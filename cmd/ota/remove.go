@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/ruixiaoedu/ota/unixsocket/pb"
+	"golang.org/x/net/context"
+	"log"
+	"path/filepath"
+)
+
+// remove 卸载包
+func remove() {
+	conn := dial()
+	defer conn.Close()
+
+	client := pb.NewOtaClient(conn)
+
+	request := &pb.RemoveRequest{Name: *removeNameArg}
+	if removeRootFlag != nil && *removeRootFlag != "" {
+		root, err := filepath.Abs(*removeRootFlag)
+		if err != nil {
+			log.Fatalln("The root is not a valid path: " + err.Error())
+			return
+		}
+		request.Root = root
+	}
+
+	removeReply, err := client.Remove(context.Background(), request)
+	if err != nil {
+		log.Fatalln("Remove fail: " + err.Error())
+		return
+	}
+	for _, warning := range removeReply.Warnings {
+		log.Println("Warning: " + warning)
+	}
+	if !removeReply.Ok {
+		log.Fatalln("Remove fail: " + removeReply.Message)
+		return
+	}
+}
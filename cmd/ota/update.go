@@ -8,6 +8,7 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 	"log"
+	"path/filepath"
 	"strings"
 )
 
@@ -19,11 +20,7 @@ func update(c *core.Core) {
 		return
 	}
 
-	conn, err := grpc.Dial("unix://"+unixsocket.SockAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if err != nil {
-		log.Fatalln("open the grpc unix socket fail: " + err.Error())
-		return
-	}
+	conn := dial()
 	defer conn.Close()
 
 	client := pb.NewOtaClient(conn)
@@ -60,17 +57,45 @@ func update(c *core.Core) {
 		updateURL = "file://" + file
 	}
 
-	updateReply, err := client.Update(context.Background(), &pb.UpdateRequest{Url: updateURL})
+	// 安装根目录由守护进程解析，需要使用绝对路径
+	var root string
+	if updateRootFlag != nil && *updateRootFlag != "" {
+		var err error
+		if root, err = filepath.Abs(*updateRootFlag); err != nil {
+			log.Fatalln("The root is not a valid path: " + err.Error())
+			return
+		}
+	}
+
+	request := &pb.UpdateRequest{Url: updateURL, Root: root}
+	if updateForceFlag != nil {
+		request.Force = *updateForceFlag
+	}
+
+	updateReply, err := client.Update(context.Background(), request)
 	if err != nil {
 		log.Fatalln("Update fail: " + err.Error())
 		return
-	} else if !updateReply.Ok {
+	}
+	for _, warning := range updateReply.Warnings {
+		log.Println("Warning: " + warning)
+	}
+	if !updateReply.Ok {
 		log.Fatalln("Update fail: " + updateReply.Message)
 		return
 	}
 
 }
 
+// dial 连接守护进程
+func dial() *grpc.ClientConn {
+	conn, err := grpc.Dial("unix://"+unixsocket.SockAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalln("open the grpc unix socket fail: " + err.Error())
+	}
+	return conn
+}
+
 // updateStandAlone 使用独立模式进行升级
 func updateStandAlone(c *core.Core) {
 
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ruixiaoedu/ota/models"
+	"github.com/ruixiaoedu/ota/unixsocket/pb"
+	"golang.org/x/net/context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// verify 校验已安装的文件，存在未修复的问题时以状态码1退出
+func verify() {
+	conn := dial()
+	defer conn.Close()
+
+	client := pb.NewOtaClient(conn)
+
+	request := &pb.VerifyRequest{}
+	if verifyNameArg != nil {
+		request.Name = *verifyNameArg
+	}
+	if verifyRepairFlag != nil {
+		request.Repair = *verifyRepairFlag
+	}
+	if verifyRootFlag != nil && *verifyRootFlag != "" {
+		root, err := filepath.Abs(*verifyRootFlag)
+		if err != nil {
+			log.Fatalln("The root is not a valid path: " + err.Error())
+			return
+		}
+		request.Root = root
+	}
+
+	verifyReply, err := client.Verify(context.Background(), request)
+	if err != nil {
+		log.Fatalln("Verify fail: " + err.Error())
+		return
+	}
+
+	results := make([]models.VerifyResult, 0, len(verifyReply.Results))
+	failed := false
+	for _, r := range verifyReply.Results {
+		results = append(results, models.VerifyResult{
+			Package:  r.Name,
+			Path:     r.Path,
+			Status:   r.Status,
+			Details:  r.Details,
+			Conffile: r.Conffile,
+			Repaired: r.Repaired,
+		})
+		if !r.Repaired {
+			failed = true
+		}
+	}
+
+	if verifyJsonFlag != nil && *verifyJsonFlag {
+		bs, _ := json.MarshalIndent(results, "", "  ")
+		fmt.Println(string(bs))
+	} else {
+		for _, r := range results {
+			fmt.Println(formatVerifyResult(r))
+		}
+	}
+
+	if !verifyReply.Ok {
+		log.Fatalln("Verify fail: " + verifyReply.Message)
+		return
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// formatVerifyResult 以文本格式输出单个校验结果，如：
+//
+//	modified  /etc/app.conf (sha256,mode) [app] conffile
+func formatVerifyResult(r models.VerifyResult) string {
+	line := fmt.Sprintf("%-9s %s", r.Status, r.Path)
+	if len(r.Details) > 0 {
+		line += " (" + strings.Join(r.Details, ",") + ")"
+	}
+	line += " [" + r.Package + "]"
+	if r.Conffile {
+		line += " conffile"
+	}
+	if r.Repaired {
+		line += " repaired"
+	}
+	return line
+}
@@ -3,14 +3,60 @@ package config
 import (
 	"gopkg.in/ini.v1"
 	"os"
+	"time"
+)
+
+const (
+	DefaultStateDir       = "/var/lib/ota"   // 默认状态目录
+	DefaultRoot           = "/"              // 默认安装根目录
+	DefaultScriptTimeout  = 10 * time.Minute // 默认脚本超时时间
+	DefaultScriptLogSize  = 1 << 20          // 默认脚本输出日志大小上限
+	DefaultMaxPackageSize = 1 << 30          // 默认升级包解压后的总大小上限
+	DefaultMaxEntrySize   = 512 << 20        // 默认升级包中单个文件的大小上限
+	DefaultMaxEntries     = 10000            // 默认升级包中的条目数量上限
 )
 
 type Config struct {
-	Keyfile string `ini:"keyfile"` // 密钥地址
+	Keyfile  string `ini:"keyfile"`   // 密钥地址，公钥ID为default
+	StateDir string `ini:"state_dir"` // 状态目录，保存备份等数据
+	Root     string `ini:"root"`      // 安装根目录，描述文件中的路径都在其下解析
+
+	KeysDir          string   `ini:"keys_dir"`                   // 受信任公钥目录，每个<公钥ID>.pem文件为一个公钥，PEM头Expires为过期时间
+	SignaturePolicy  []string `ini:"signature_policy" delim:"|"` // 签名策略，规则之间用|分隔，每条规则为"数量:公钥ID,公钥ID"，*为任意公钥，为空时需要一个有效签名
+	RequireSignature *bool    `ini:"require_signature"`          // 是否拒绝未签名的升级包，未配置时设置了公钥就要求签名
+
+	AllowPaths          []string `ini:"allow_paths" delim:","` // 允许写入的路径前缀（相对安装根目录），为空时不限制
+	DenyPaths           []string `ini:"deny_paths" delim:","`  // 禁止写入的路径前缀，优先于allow_paths
+	AllowDevices        bool     `ini:"allow_devices"`         // 是否允许写入设备文件
+	AllowSetuid         bool     `ini:"allow_setuid"`          // 是否允许设置setuid和setgid位
+	AllowSecurityXattrs bool     `ini:"allow_security_xattrs"` // 是否允许设置security.*扩展属性，文件能力可以赋予与setuid相当的权限
+
+	MaxPackageSize int64 `ini:"max_package_size"` // 升级包解压后的总大小上限（字节），为0时不限制
+	MaxEntrySize   int64 `ini:"max_entry_size"`   // 升级包中单个文件的大小上限（字节），为0时不限制
+	MaxEntries     int   `ini:"max_entries"`      // 升级包中的条目数量上限，为0时不限制
+	LegacyLayout   bool  `ini:"legacy_layout"`    // 接受描述文件和签名不在tar包开头的旧格式升级包，解压完成后才验证签名
+
+	ScriptTimeout        time.Duration `ini:"script_timeout"`         // 脚本默认超时时间，为0时不限制
+	ScriptUser           string        `ini:"script_user"`            // 运行脚本的默认用户，为空时与守护进程相同
+	ScriptGroup          string        `ini:"script_group"`           // 运行脚本的默认用户组
+	ScriptLimitCpu       uint64        `ini:"script_limit_cpu"`       // 脚本CPU时间限制（秒）
+	ScriptLimitMemory    uint64        `ini:"script_limit_memory"`    // 脚本虚拟内存限制（字节）
+	ScriptLimitFiles     uint64        `ini:"script_limit_files"`     // 脚本打开文件数限制
+	ScriptLimitProcesses uint64        `ini:"script_limit_processes"` // 脚本用户进程数限制
+	ScriptPrivateTmp     bool          `ini:"script_private_tmp"`     // 脚本是否使用独立的临时目录
+	ScriptLogSize        int64         `ini:"script_log_size"`        // 每次升级的脚本输出日志大小上限（字节），为0时不限制
 }
 
 func NewConfig(filename string) (*Config, error) {
-	var cfg Config
+	var cfg = Config{
+		StateDir:       DefaultStateDir,
+		Root:           DefaultRoot,
+		MaxPackageSize: DefaultMaxPackageSize,
+		MaxEntrySize:   DefaultMaxEntrySize,
+		MaxEntries:     DefaultMaxEntries,
+		ScriptTimeout:  DefaultScriptTimeout,
+		ScriptLogSize:  DefaultScriptLogSize,
+	}
 	if err := ini.MapTo(&cfg, filename); err != nil {
 		if os.IsNotExist(err) {
 			// 不存在，设置为空
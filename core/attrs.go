@@ -0,0 +1,150 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ruixiaoedu/ota/models"
+	"os"
+	"os/user"
+	"strconv"
+	"time"
+)
+
+// fileAttrs 安装文件的权限、所有者、修改时间和扩展属性
+type fileAttrs struct {
+	mode   *os.FileMode      // 为空时不修改
+	uid    int               // 为-1时不修改
+	gid    int               // 为-1时不修改
+	mtime  *time.Time        // 为空时不修改
+	xattrs map[string][]byte // 需要设置的扩展属性
+}
+
+// parseFileAttrs 解析描述文件中的文件属性
+func parseFileAttrs(v models.File) (*fileAttrs, error) {
+	attrs := &fileAttrs{uid: -1, gid: -1}
+
+	if v.Mode != "" {
+		mode, err := parseMode(v.Mode)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", v.Path, err)
+		}
+		attrs.mode = &mode
+	}
+
+	if v.Uid != nil && v.Owner != "" {
+		return nil, fmt.Errorf("%s: uid and owner cannot coexist", v.Path)
+	} else if v.Uid != nil {
+		attrs.uid = *v.Uid
+	} else if v.Owner != "" {
+		u, err := user.Lookup(v.Owner)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", v.Path, err)
+		}
+		if attrs.uid, err = strconv.Atoi(u.Uid); err != nil {
+			return nil, err
+		}
+	}
+
+	if v.Gid != nil && v.Group != "" {
+		return nil, fmt.Errorf("%s: gid and group cannot coexist", v.Path)
+	} else if v.Gid != nil {
+		attrs.gid = *v.Gid
+	} else if v.Group != "" {
+		g, err := user.LookupGroup(v.Group)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", v.Path, err)
+		}
+		if attrs.gid, err = strconv.Atoi(g.Gid); err != nil {
+			return nil, err
+		}
+	}
+
+	if v.Mtime != 0 {
+		mtime := time.Unix(v.Mtime, 0)
+		attrs.mtime = &mtime
+	}
+
+	for name, value := range v.Xattrs {
+		if name == "" {
+			return nil, fmt.Errorf("%s: empty xattr name", v.Path)
+		}
+		bs, err := parseXattrValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid xattr %s: %v", v.Path, name, err)
+		}
+		if attrs.xattrs == nil {
+			attrs.xattrs = make(map[string][]byte)
+		}
+		attrs.xattrs[name] = bs
+	}
+
+	return attrs, nil
+}
+
+// parseMode 解析八进制的权限，支持setuid、setgid和sticky位
+func parseMode(s string) (os.FileMode, error) {
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m > 07777 {
+		return 0, errors.New("invalid mode " + s)
+	}
+
+	mode := os.FileMode(m & 0777)
+	if m&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if m&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if m&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode, nil
+}
+
+// apply 在重命名前设置到临时文件上，先修改所有者再修改权限，避免chown清除setuid位
+func (a *fileAttrs) apply(f *os.File) error {
+	if a.uid != -1 || a.gid != -1 {
+		if err := f.Chown(a.uid, a.gid); err != nil {
+			return err
+		}
+	}
+	if a.mode != nil {
+		if err := f.Chmod(*a.mode); err != nil {
+			return err
+		}
+	}
+	if a.mtime != nil {
+		if err := os.Chtimes(f.Name(), *a.mtime, *a.mtime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyXattrs 在所有者和权限之后设置扩展属性，chown会清除security.capability
+func (a *fileAttrs) applyXattrs(name string) error {
+	return setXattrs(name, a.xattrs)
+}
+
+// applyPath 设置到已安装的目录或符号链接上，符号链接只修改所有者和扩展属性
+func (a *fileAttrs) applyPath(name string, symlink bool) error {
+	if a.uid != -1 || a.gid != -1 {
+		if err := os.Lchown(name, a.uid, a.gid); err != nil {
+			return err
+		}
+	}
+	if symlink {
+		return a.applyXattrs(name)
+	}
+	if a.mode != nil {
+		if err := os.Chmod(name, *a.mode); err != nil {
+			return err
+		}
+	}
+	if a.mtime != nil {
+		if err := os.Chtimes(name, *a.mtime, *a.mtime); err != nil {
+			return err
+		}
+	}
+	return a.applyXattrs(name)
+}
@@ -0,0 +1,83 @@
+package core
+
+import (
+	"github.com/ruixiaoedu/ota/models"
+	"github.com/ruixiaoedu/ota/utils"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// objectDir 已安装文件的缓存目录，文件按SHA256保存，用于修复被损坏的文件
+func (core *Core) objectDir() string {
+	return path.Join(core.stateDir, "objects")
+}
+
+// objectPath 缓存文件的路径
+func (core *Core) objectPath(sha256 string) string {
+	return path.Join(core.objectDir(), sha256)
+}
+
+// cacheObjects 将升级包中的普通文件保存到缓存，优先使用硬链接
+// record与description中的文件一一对应，已缓存的文件跳过
+func (core *Core) cacheObjects(dir string, description *models.Description, record *models.Package) error {
+	if err := os.MkdirAll(core.objectDir(), 0700); err != nil {
+		return err
+	}
+
+	for i, v := range description.Files {
+		sha256 := record.Files[i].Sha256
+		if sha256 == "" || utils.FileExist(core.objectPath(sha256)) {
+			continue
+		}
+
+		source := path.Join(dir, v.Filename)
+		if err := os.Link(source, core.objectPath(sha256)); err == nil || os.IsExist(err) {
+			continue
+		}
+
+		f, err := os.Open(source)
+		if err != nil {
+			return err
+		}
+		err = utils.WriteFileAtomic(core.objectPath(sha256), f, nil)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gcObjects 删除不再被任何已安装包引用的缓存文件
+func (core *Core) gcObjects() error {
+	pkgs, err := core.packages()
+	if err != nil {
+		return err
+	}
+
+	referenced := make(map[string]struct{})
+	for _, pkg := range pkgs {
+		for _, f := range pkg.Files {
+			if f.Sha256 != "" {
+				referenced[f.Sha256] = struct{}{}
+			}
+		}
+	}
+
+	entries, err := ioutil.ReadDir(core.objectDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, v := range entries {
+		if _, ok := referenced[v.Name()]; !ok {
+			if err = os.Remove(core.objectPath(v.Name())); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/ruixiaoedu/ota/utils"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// conffileSuffix 本地修改过的配置文件，新版本另存时使用的后缀
+const conffileSuffix = ".ota-new"
+
+// conffilesPath 已安装配置文件的记录路径
+func (core *Core) conffilesPath() string {
+	return path.Join(core.stateDir, "conffiles.json")
+}
+
+// conffiles 读取已安装配置文件的SHA256，键为安装路径
+func (core *Core) conffiles() map[string]string {
+	m := make(map[string]string)
+	bs, err := ioutil.ReadFile(core.conffilesPath())
+	if err != nil {
+		return m
+	}
+	if err = json.Unmarshal(bs, &m); err != nil {
+		return make(map[string]string)
+	}
+	return m
+}
+
+// saveConffiles 将本次安装的配置文件合并到记录中
+func (core *Core) saveConffiles(installed map[string]string) error {
+	if len(installed) == 0 {
+		return nil
+	}
+
+	m := core.conffiles()
+	for k, v := range installed {
+		m[k] = v
+	}
+
+	bs, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(core.stateDir, 0755); err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(core.conffilesPath(), bytes.NewReader(bs), nil)
+}
+
+// conffileDest 决定配置文件的安装位置
+// 已有文件与上次安装的内容一致时直接覆盖，否则视为本地修改过，新版本另存为<path>.ota-new
+// 返回空字符串表示已有文件与新版本相同，无需安装
+func conffileDest(name, hash string, known map[string]string) (string, error) {
+	fi, err := os.Lstat(name)
+	if os.IsNotExist(err) {
+		return name, nil
+	} else if err != nil {
+		return "", err
+	}
+	if !fi.Mode().IsRegular() {
+		return name + conffileSuffix, nil
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	current, err := utils.Sha256FromReader(f)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+
+	switch current {
+	case hash:
+		return "", nil
+	case known[name]:
+		return name, nil
+	default:
+		return name + conffileSuffix, nil
+	}
+}
+
+// conffileWarning 配置文件未被覆盖的警告
+func conffileWarning(name string) string {
+	return fmt.Sprintf("%s has local changes, new version installed as %s", name, name+conffileSuffix)
+}
@@ -0,0 +1,211 @@
+package core
+
+import (
+	"fmt"
+	"github.com/ruixiaoedu/ota/models"
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// scriptShim 设置资源限制后再执行脚本的辅助进程名称
+// 守护进程以此名称重新执行自身，辅助进程设置好限制并切换用户后exec目标程序，避免修改守护进程自身的限制
+const scriptShim = "ota-script-shim"
+
+func init() {
+	if len(os.Args) > 0 && os.Args[0] == scriptShim {
+		runShim(os.Args[1:])
+	}
+}
+
+// shimResources 辅助进程参数中依次对应的资源
+var shimResources = []int{unix.RLIMIT_CPU, unix.RLIMIT_AS, unix.RLIMIT_NOFILE, unix.RLIMIT_NPROC}
+
+// runShim 设置资源限制、切换用户后执行目标程序
+// 参数依次为用户ID、用户组ID（为-1时不切换）、各项资源限制、目标程序路径和目标程序参数
+func runShim(args []string) {
+	fail := func(format string, a ...interface{}) {
+		fmt.Fprintf(os.Stderr, "ota-script-shim: "+format+"\n", a...)
+		os.Exit(127)
+	}
+
+	if len(args) < len(shimResources)+4 {
+		fail("missing arguments")
+	}
+
+	uid, err := strconv.Atoi(args[0])
+	if err != nil {
+		fail("invalid uid %s", args[0])
+	}
+	gid, err := strconv.Atoi(args[1])
+	if err != nil {
+		fail("invalid gid %s", args[1])
+	}
+
+	limits := args[2 : 2+len(shimResources)]
+	for i, resource := range shimResources {
+		v, err := strconv.ParseUint(limits[i], 10, 64)
+		if err != nil {
+			fail("invalid limit %s", limits[i])
+		}
+		if v == 0 {
+			continue
+		}
+		if err = unix.Setrlimit(resource, &unix.Rlimit{Cur: v, Max: v}); err != nil {
+			fail("set limit %s fail: %v", limits[i], err)
+		}
+	}
+
+	// 先设置限制再降低权限，普通用户无法再提高限制
+	if gid >= 0 {
+		if err = syscall.Setgroups([]int{}); err != nil {
+			fail("set groups fail: %v", err)
+		}
+		if err = syscall.Setgid(gid); err != nil {
+			fail("set gid %d fail: %v", gid, err)
+		}
+	}
+	if uid >= 0 {
+		if err = syscall.Setuid(uid); err != nil {
+			fail("set uid %d fail: %v", uid, err)
+		}
+	}
+
+	target := args[2+len(shimResources):]
+	err = unix.Exec(target[0], target[1:], os.Environ())
+	fail("exec %s fail: %v", target[0], err)
+}
+
+// confine 按脚本和全局配置设置运行用户、资源限制和临时目录，返回的release用于清理
+func (core *Core) confine(cmd *exec.Cmd, script models.Script) (*exec.Cmd, func(), error) {
+	release := func() {}
+
+	userName, groupName := script.User, script.Group
+	if userName == "" && groupName == "" {
+		userName, groupName = core.scriptDefault.User, core.scriptDefault.Group
+	}
+
+	credential, err := lookupCredential(userName, groupName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 独立的临时目录，属于运行脚本的用户
+	privateTmp := *core.scriptDefault.PrivateTmp
+	if script.PrivateTmp != nil {
+		privateTmp = *script.PrivateTmp
+	}
+	if privateTmp {
+		dir, err := ioutil.TempDir("", "ota-script-")
+		if err != nil {
+			return nil, nil, err
+		}
+		release = func() { os.RemoveAll(dir) }
+		if credential != nil {
+			if err = os.Chown(dir, int(credential.Uid), int(credential.Gid)); err != nil {
+				release()
+				return nil, nil, err
+			}
+		}
+		cmd.Env = append(cmd.Env, "TMPDIR="+dir)
+	}
+
+	// 有资源限制时，通过辅助进程执行
+	limits := *core.scriptDefault.Limits
+	if script.Limits != nil {
+		if script.Limits.Cpu != 0 {
+			limits.Cpu = script.Limits.Cpu
+		}
+		if script.Limits.Memory != 0 {
+			limits.Memory = script.Limits.Memory
+		}
+		if script.Limits.Files != 0 {
+			limits.Files = script.Limits.Files
+		}
+		if script.Limits.Processes != 0 {
+			limits.Processes = script.Limits.Processes
+		}
+	}
+	if limits == (models.Limits{}) {
+		if credential != nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{Credential: credential}
+		}
+		return cmd, release, nil
+	}
+
+	// 有资源限制时，通过辅助进程设置限制并切换用户
+	self, err := selfExecutable()
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	uid, gid := "-1", "-1"
+	if credential != nil {
+		uid, gid = strconv.Itoa(int(credential.Uid)), strconv.Itoa(int(credential.Gid))
+	}
+	args := []string{
+		scriptShim,
+		uid,
+		gid,
+		strconv.FormatUint(limits.Cpu, 10),
+		strconv.FormatUint(limits.Memory, 10),
+		strconv.FormatUint(limits.Files, 10),
+		strconv.FormatUint(limits.Processes, 10),
+		cmd.Path,
+	}
+	shim := &exec.Cmd{
+		Path: self,
+		Args: append(args, cmd.Args...),
+		Env:  cmd.Env,
+		Dir:  cmd.Dir,
+	}
+
+	return shim, release, nil
+}
+
+// lookupCredential 查找用户和用户组，都为空时返回nil，即与守护进程相同
+func lookupCredential(userName, groupName string) (*syscall.Credential, error) {
+	if userName == "" && groupName == "" {
+		return nil, nil
+	}
+
+	credential := &syscall.Credential{
+		Uid:    uint32(os.Getuid()),
+		Gid:    uint32(os.Getgid()),
+		Groups: []uint32{},
+	}
+
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			return nil, err
+		}
+		uid, err := strconv.ParseUint(u.Uid, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		gid, err := strconv.ParseUint(u.Gid, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		credential.Uid, credential.Gid = uint32(uid), uint32(gid)
+	}
+
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			return nil, err
+		}
+		gid, err := strconv.ParseUint(g.Gid, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		credential.Gid = uint32(gid)
+	}
+
+	return credential, nil
+}
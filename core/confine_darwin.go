@@ -0,0 +1,8 @@
+package core
+
+import "os"
+
+// selfExecutable 当前程序的路径
+func selfExecutable() (string, error) {
+	return os.Executable()
+}
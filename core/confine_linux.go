@@ -0,0 +1,6 @@
+package core
+
+// selfExecutable 当前程序的路径，程序文件被升级替换后仍指向正在运行的程序
+func selfExecutable() (string, error) {
+	return "/proc/self/exe", nil
+}
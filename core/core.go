@@ -3,7 +3,6 @@ package core
 import (
 	"archive/tar"
 	"compress/gzip"
-	"crypto/rsa"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -15,105 +14,311 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
 	"path"
-	"strings"
+	"path/filepath"
+	"sync"
+	"time"
 )
 
 // NewCore 创建核心程序
 func NewCore(cfg *config.Config) *Core {
 
-	var publicKey *rsa.PublicKey = nil
-	if cfg.Keyfile != "" {
-		var err error
-		publicKey, err = utils.ParsePublicKeyFromFile(cfg.Keyfile)
-		if err != nil {
-			log.Fatal("public key init fail: " + err.Error())
-		}
+	keys, err := loadKeys(cfg.Keyfile, cfg.KeysDir)
+	if err != nil {
+		log.Fatal("public key init fail: " + err.Error())
+	}
+	signaturePolicy, err := parseSignaturePolicy(cfg.SignaturePolicy, keys)
+	if err != nil {
+		log.Fatal("signature policy init fail: " + err.Error())
+	}
+
+	stateDir := cfg.StateDir
+	if stateDir == "" {
+		stateDir = config.DefaultStateDir
+	}
+
+	root := cfg.Root
+	if root == "" {
+		root = config.DefaultRoot
+	}
+
+	// 未配置时，设置了公钥就要求签名
+	requireSignature := len(keys) > 0
+	if cfg.RequireSignature != nil {
+		requireSignature = *cfg.RequireSignature
 	}
 
 	return &Core{
-		pubKey: publicKey,
+		keys:             keys,
+		signaturePolicy:  signaturePolicy,
+		requireSignature: requireSignature,
+		stateDir:         stateDir,
+		root:             root,
+		policy: pathPolicy{
+			allow:   cfg.AllowPaths,
+			deny:    cfg.DenyPaths,
+			devices: cfg.AllowDevices,
+			setuid:  cfg.AllowSetuid,
+			xattrs:  cfg.AllowSecurityXattrs,
+		},
+		limits: archiveLimits{
+			total:   cfg.MaxPackageSize,
+			entry:   cfg.MaxEntrySize,
+			entries: cfg.MaxEntries,
+		},
+		legacyLayout:  cfg.LegacyLayout,
+		scriptTimeout: cfg.ScriptTimeout,
+		scriptLogSize: cfg.ScriptLogSize,
+		scriptDefault: models.Script{
+			User:  cfg.ScriptUser,
+			Group: cfg.ScriptGroup,
+			Limits: &models.Limits{
+				Cpu:       cfg.ScriptLimitCpu,
+				Memory:    cfg.ScriptLimitMemory,
+				Files:     cfg.ScriptLimitFiles,
+				Processes: cfg.ScriptLimitProcesses,
+			},
+			PrivateTmp: &cfg.ScriptPrivateTmp,
+		},
 	}
 }
 
 // Core 核心
 type Core struct {
-	pubKey *rsa.PublicKey // 验签用的公钥
+	keys             map[string]trustedKey // 验签用的受信任公钥，按公钥ID索引
+	signaturePolicy  []signatureRule       // 有效签名需满足的规则
+	requireSignature bool                  // 是否拒绝未签名的升级包
+	stateDir         string                // 状态目录
+	root             string                // 默认安装根目录
+	policy           pathPolicy            // 升级包可以写入的路径
+	limits           archiveLimits         // 解压升级包的大小和数量限制
+	legacyLayout     bool                  // 是否接受描述文件不在开头的旧格式升级包
+	scriptTimeout    time.Duration         // 脚本默认超时时间
+	scriptDefault    models.Script         // 脚本默认的用户和资源限制
+	scriptLogSize    int64                 // 每次升级的脚本输出日志大小上限
+	mu               sync.Mutex            // 同一时间只允许一个安装
 }
 
 // UpdateFromLocalFile 从本地文件中进行升级
-func (core *Core) UpdateFromLocalFile(filename string) error {
+func (core *Core) UpdateFromLocalFile(filename string, opts *models.UpdateOptions) (*models.History, error) {
 
 	f, err := os.Open(filename)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer f.Close()
 
-	return core.Update(f)
+	return core.Update(f, opts)
 }
 
 // UpdateFromUrl 从网络进行升级
-func (core *Core) UpdateFromUrl(url string) error {
+func (core *Core) UpdateFromUrl(url string, opts *models.UpdateOptions) (*models.History, error) {
 
 	resp, err := http.Get(url)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	return core.Update(resp.Body)
+	return core.Update(resp.Body, opts)
 }
 
-// Update OTA升级
-func (core *Core) Update(reader io.Reader) error {
+// Update OTA升级，返回本次升级的记录，解压前失败时记录为空
+// opts为空时使用默认选项
+func (core *Core) Update(reader io.Reader, opts *models.UpdateOptions) (*models.History, error) {
+	core.mu.Lock()
+	defer core.mu.Unlock()
+
+	root, err := core.installRoot(opts)
+	if err != nil {
+		return nil, err
+	}
+
 	// 读取压缩数据
 	gr, err := gzip.NewReader(reader)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer gr.Close()
 
-	// 创建临时目录
-	tempDir, err := ioutil.TempDir("", "ota-")
+	// 创建解压目录，放在状态目录中，以便异常中断后恢复
+	pkgDir := path.Join(core.stateDir, "package")
+	if err = os.RemoveAll(pkgDir); err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(pkgDir, 0755); err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(pkgDir)
+
+	// 严格地解压tar包，旧格式的升级包在解压后才验证签名
+	var manifest func(dir string) (*models.Description, error)
+	if !core.legacyLayout {
+		manifest = core.loadDescription
+	}
+	ar, err := extract(tar.NewReader(gr), pkgDir, core.limits, manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	j := newJournal(core.journalPath(), pkgDir, root)
+	if opts != nil {
+		j.Force = opts.Force
+	}
+	if err = j.step(stepExtracted); err != nil {
+		return nil, err
+	}
+
+	output, err := core.openScriptLog(j.Id)
+	if err != nil {
+		return nil, err
+	}
+	defer output.Close()
+
+	err = core.updateFromDir(pkgDir, j, output, ar)
+	h, herr := core.recordHistory(j, false, err)
+	if herr != nil {
+		log.Println("record history fail", herr)
+	}
+	if jerr := j.remove(); jerr != nil {
+		log.Println("remove journal fail", jerr)
+	}
+
+	return h, err
+}
+
+// installRoot 本次升级的安装根目录，必须为已存在的目录
+func (core *Core) installRoot(opts *models.UpdateOptions) (string, error) {
+	root := core.root
+	if opts != nil && opts.Root != "" {
+		root = opts.Root
+	}
+	if !filepath.IsAbs(root) {
+		return "", fmt.Errorf("install root %s must be an absolute path", root)
+	}
+	fi, err := os.Stat(root)
+	if err != nil {
+		return "", err
+	}
+	if !fi.IsDir() {
+		return "", fmt.Errorf("install root %s is not a directory", root)
+	}
+	return filepath.Clean(root), nil
+}
+
+// journalPath 安装日志路径
+func (core *Core) journalPath() string {
+	return path.Join(core.stateDir, "journal.json")
+}
+
+// backupDir 备份目录
+func (core *Core) backupDir() string {
+	return path.Join(core.stateDir, "backup")
+}
+
+// removeScriptDir 升级保存卸载脚本的目录
+func (core *Core) removeScriptDir(id string) string {
+	return path.Join(core.stateDir, "scripts", id)
+}
+
+// Recover 恢复异常中断的安装，需在服务启动前调用
+// 文件已全部复制时继续完成安装，否则回滚
+func (core *Core) Recover() error {
+	core.mu.Lock()
+	defer core.mu.Unlock()
+
+	j, err := loadJournal(core.journalPath())
 	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
 		return err
 	}
-	defer os.RemoveAll(tempDir)
+	if j.Package != "" {
+		defer os.RemoveAll(j.Package)
+	}
 
-	// 解析tar包内容
-	tr := tar.NewReader(gr)
-	for {
-		hdr, err := tr.Next()
-		if err != nil {
-			if err == io.EOF {
-				break
-			} else {
-				return err
-			}
+	operation := "update"
+	switch j.Operation {
+	case models.OperationRemove:
+		operation = "removal"
+	case models.OperationRepair:
+		operation = "repair"
+	}
+	log.Printf("recovering interrupted %s %s %s at step %s", operation, j.Name, j.Version, j.Step)
+
+	tx := &transaction{dir: core.backupDir(), entries: j.Backups, journal: j}
+	core.onCommit(tx)
+
+	// 脚本输出追加到中断的升级的日志中
+	var output *scriptLog
+	if j.Id != "" {
+		if output, err = core.openScriptLog(j.Id); err != nil {
+			log.Println("open script log fail", err)
 		}
+		defer output.Close()
+	}
 
-		filename := path.Join(tempDir, hdr.Name)
-		file, err := utils.CreateFile(filename)
-		if err != nil {
-			return err
+	// 升级包仍然完整时，可以继续执行其中的脚本；卸载时执行保存的卸载脚本
+	var env *scriptEnv
+	var scripts map[string][]models.Script
+	var derr error
+	switch j.Operation {
+	case models.OperationRemove:
+		if j.Record == nil {
+			derr = errors.New("package record of the removal is missing")
+		} else {
+			env = core.newRemoveEnv(j.Record, output)
+			scripts = scriptsByType(j.Record.Scripts)
 		}
-		_, err = io.Copy(file, tr)
-		if err != nil {
-			return err
+	case models.OperationRepair:
+		// 修复不执行脚本，也没有升级包
+	default:
+		var description *models.Description
+		if description, derr = core.loadDescription(j.Package); derr == nil {
+			env = core.newScriptEnv(j.Package, j.Root, description, output)
+			scripts = scriptsByType(description.Scripts)
+			core.onRollback(tx, env, scripts)
 		}
-		if err = file.Chmod(os.FileMode(hdr.Mode) | os.FileMode(0444)); err != nil {
-			log.Println("chmod file mode fail", err)
+	}
+
+	switch j.Step {
+	case stepPostinstalled:
+		err = tx.commit()
+	case stepInstalled:
+		// 文件已全部修改，继续执行之后的脚本
+		if derr != nil {
+			err = tx.abort(derr)
+		} else if j.Operation == models.OperationRepair {
+			err = tx.commit()
+		} else if j.Operation == models.OperationRemove {
+			err = core.finish(tx, env, scripts, models.ScriptPostremove)
+		} else {
+			err = core.finish(tx, env, scripts, models.ScriptPostinstall, models.ScriptVerify)
+		}
+	case stepInstalling:
+		err = tx.abort(fmt.Errorf("%s was interrupted at step %s", operation, j.Step))
+	default:
+		// 尚未修改任何文件，不提交事务，以免写入数据库
+		err = fmt.Errorf("%s was interrupted at step %s", operation, j.Step)
+		if rerr := os.RemoveAll(tx.dir); rerr != nil {
+			log.Println("remove backup fail", rerr)
 		}
 	}
 
-	return core.updateFromDir(tempDir)
+	if _, herr := core.recordHistory(j, true, err); herr != nil {
+		log.Println("record history fail", herr)
+	}
+	if jerr := j.remove(); jerr != nil {
+		return jerr
+	}
+
+	return err
 }
 
-// updateFromDir 从文件夹中升级
-func (core *Core) updateFromDir(dir string) error {
+// loadDescription 读取描述文件并验证签名
+func (core *Core) loadDescription(dir string) (*models.Description, error) {
 	var err error
 
 	// OTA描述文件是否存在
@@ -121,33 +326,75 @@ func (core *Core) updateFromDir(dir string) error {
 	var desFilePath = path.Join(dir, "ota-description.json")
 	descriptionByte, err = ioutil.ReadFile(desFilePath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// OTA签名是否存在，如果存在，则验证签名的正确性
 	var sigFilePath = path.Join(dir, "ota-description.sig")
 	if utils.FileExist(sigFilePath) {
-		if core.pubKey == nil {
-			return errors.New("update file has sign, but public key is empty")
+		if len(core.keys) == 0 {
+			return nil, errors.New("update file has sign, but public key is empty")
 		}
 
 		var bs []byte
 		bs, err = ioutil.ReadFile(sigFilePath)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		if !utils.VerifySignWithSha256(descriptionByte, strings.TrimSpace(string(bs)), core.pubKey) {
-			return errors.New("sign is not right")
+		if err = core.verifySignatures(descriptionByte, bs); err != nil {
+			return nil, err
 		}
+	} else if core.requireSignature {
+		return nil, errors.New("update file has no sign, but signature is required")
 	}
 
 	// 解析description文件
 	var description models.Description
 	err = json.Unmarshal(descriptionByte, &description)
+	if err != nil {
+		return nil, err
+	}
+
+	return &description, nil
+}
+
+// updateFromDir 从文件夹中升级，ar为解压tar包时记录的扩展属性和其他类型的条目
+func (core *Core) updateFromDir(dir string, j *journal, output *scriptLog, ar *archive) error {
+	description, err := core.loadDescription(dir)
 	if err != nil {
 		return err
 	}
+	j.Name, j.Version = description.Name, description.Version
+
+	// tar包头不受签名保护，签名的升级包只使用描述文件中的扩展属性
+	if utils.FileExist(path.Join(dir, "ota-description.sig")) {
+		if len(ar.xattrs) > 0 {
+			log.Println("ignore xattrs in archive headers of signed package")
+		}
+	} else {
+		mergeXattrs(description, ar.xattrs)
+	}
+
+	// 要求签名时，包中的文件必须都受签名的描述文件保护
+	if core.requireSignature {
+		if err = checkListed(dir, description); err != nil {
+			return err
+		}
+	}
+
+	// 描述文件验证通过后，才创建其声明的其他类型的条目
+	if err = createEntries(dir, description, ar.entries, core.policy.devices); err != nil {
+		return err
+	}
+
+	// 所有路径都在安装根目录下解析，并在写入任何内容之前检查路径策略
+	if err = resolvePaths(description, j.Root); err != nil {
+		return err
+	}
+	if err = core.policy.check(description, j.Root, core.stateDir); err != nil {
+		return err
+	}
 
 	// 验证文件
 	var files []struct {
@@ -156,13 +403,34 @@ func (core *Core) updateFromDir(dir string) error {
 		Sha256   string
 	}
 
-	var preinstalls []models.Script
-	var postinstalls []models.Script
+	var scripts = make(map[string][]models.Script)
+
+	for _, v := range description.Remove {
+		if err = checkRemove(v); err != nil {
+			return err
+		}
+	}
 
 	for _, v := range description.Files {
-		if !utils.FileExist(path.Join(dir, v.Filename)) {
+		if err = checkEntry(v, description); err != nil {
+			return err
+		}
+		if fileType(v) != models.FileRegular {
+			continue
+		}
+		if core.requireSignature && v.Sha256 == "" {
+			return fmt.Errorf("%s: sha256 is required when signature is required", v.Filename)
+		}
+		fi, err := os.Lstat(path.Join(dir, v.Filename))
+		if err != nil {
 			return errors.New("文件不存在")
 		}
+		if !fi.Mode().IsRegular() {
+			return fmt.Errorf("%s is not a regular file in the package", v.Filename)
+		}
+		if v.Size > 0 && fi.Size() != v.Size {
+			return fmt.Errorf("%s: size is %d bytes, expect %d", v.Filename, fi.Size(), v.Size)
+		}
 
 		files = append(files, struct {
 			Filename string
@@ -172,29 +440,37 @@ func (core *Core) updateFromDir(dir string) error {
 	}
 
 	for _, v := range description.Scripts {
-		file, err := os.Open(path.Join(dir, v.Filename))
-		if err != nil {
-			return err
-		}
-		fi, err := file.Stat()
-		if err != nil {
-			file.Close()
-			return err
+		switch v.OnFailure {
+		case "", models.OnFailureAbort, models.OnFailureIgnore, models.OnFailureRollback:
+		default:
+			return errors.New("无效的on_failure")
 		}
 
 		switch v.Type {
-		case "preinstall":
-			preinstalls = append(preinstalls, v)
-		case "postinstall":
-			postinstalls = append(postinstalls, v)
+		case models.ScriptPrecheck, models.ScriptPreinstall, models.ScriptPostinstall, models.ScriptVerify,
+			models.ScriptRollback, models.ScriptPreremove, models.ScriptPostremove:
+			scripts[v.Type] = append(scripts[v.Type], v)
 		default:
-			file.Close()
 			return errors.New("无效的type")
 		}
 
-		if err = file.Chmod(fi.Mode() | os.FileMode(0111)); err != nil {
-			file.Close()
-			log.Println("chmod file mode fail", err)
+		// 内联脚本包含在描述文件中，已由签名保护
+		if v.Content != "" {
+			if v.Filename != "" {
+				return errors.New("script filename and content cannot coexist")
+			}
+			continue
+		}
+
+		if core.requireSignature && v.Sha256 == "" {
+			return fmt.Errorf("%s: sha256 is required when signature is required", v.Filename)
+		}
+		fi, err := os.Lstat(path.Join(dir, v.Filename))
+		if err != nil {
+			return errors.New("文件不存在")
+		}
+		if !fi.Mode().IsRegular() {
+			return fmt.Errorf("%s is not a regular file in the package", v.Filename)
 		}
 
 		files = append(files, struct {
@@ -205,133 +481,157 @@ func (core *Core) updateFromDir(dir string) error {
 	}
 
 	for _, v := range files {
-
-		// 验证MD5
-		if v.Md5 != "" {
-			f, err := os.Open(path.Join(dir, v.Filename))
-			if err != nil {
-				return err
-			}
-			hex, err := utils.Md5FromReader(f)
-			if err != nil {
-				f.Close()
-				return err
-			}
-			if hex != v.Md5 {
-				f.Close()
-				return fmt.Errorf("%s md5 is not right", v.Filename)
-			}
-			f.Close()
+		if err = verifyFile(path.Join(dir, v.Filename), v.Md5, v.Sha256); err != nil {
+			return err
 		}
+	}
 
-		// 验证SHA256
-		if v.Sha256 != "" {
-			f, err := os.Open(path.Join(dir, v.Filename))
-			if err != nil {
-				return err
-			}
-			hex, err := utils.Sha256FromReader(f)
-			if err != nil {
-				f.Close()
-				return err
-			}
-			if hex != v.Sha256 {
-				f.Close()
-				return fmt.Errorf("%s sha256 is not right", v.Filename)
-			}
-			f.Close()
-		}
+	// 检查路径是否属于其他包，生成安装完成后写入数据库的记录
+	if err = core.checkOwnership(description, j.Root, j.Force); err != nil {
+		return err
+	}
+	if err = core.checkSpace(dir, description); err != nil {
+		return err
+	}
+	record, err := newPackageRecord(dir, j.Root, core.removeScriptDir(j.Id), description)
+	if err != nil {
+		return err
+	}
+	if err = core.cacheObjects(dir, description, record); err != nil {
+		return err
 	}
+	if err = j.saveRecord(record); err != nil {
+		return err
+	}
+	j.Removed = description.Remove
 
-	// 执行预执行文件
-	for _, v := range preinstalls {
-		execute(path.Join(dir, v.Filename))
+	if err = j.step(stepVerified); err != nil {
+		return err
 	}
 
-	// 复制文件
-	for _, v := range description.Files {
-		destination, err := utils.CreateFile(v.Path)
-		if err != nil {
-			return err
-		}
-		source, err := os.Open(path.Join(dir, v.Filename))
-		if err != nil {
-			destination.Close()
-			return err
-		}
-		if _, err = io.Copy(destination, source); err != nil {
-			destination.Close()
-			source.Close()
-			return err
-		}
-		destination.Close()
-		source.Close()
+	// 执行检查脚本和预执行文件，此时尚未修改任何内容
+	env := core.newScriptEnv(dir, j.Root, description, output)
+	if _, err = core.runScripts(env, scripts[models.ScriptPrecheck]); err != nil {
+		return err
+	}
+	if _, err = core.runScripts(env, scripts[models.ScriptPreinstall]); err != nil {
+		return err
 	}
 
-	// 执行完成执行文件
-	for _, v := range postinstalls {
-		execute(path.Join(dir, v.Filename))
+	if err = j.step(stepPreinstalled); err != nil {
+		return err
 	}
 
-	return err
+	// 镜像目录中属于其他包的路径需要保留
+	others, err := core.otherPaths(description.Name, j.Root)
+	if err != nil {
+		return err
+	}
+
+	// 开启安装事务，失败时回滚
+	tx, err := newTransaction(core.backupDir(), j)
+	if err != nil {
+		return err
+	}
+	core.onRollback(tx, env, scripts)
+	core.onCommit(tx)
+
+	if err = install(tx, dir, description, core.conffiles(), others); err != nil {
+		return tx.abort(err)
+	}
+
+	if err = j.step(stepInstalled); err != nil {
+		return tx.abort(err)
+	}
+
+	return core.finish(tx, env, scripts, models.ScriptPostinstall, models.ScriptVerify)
 }
 
-// 打印输出
-func asyncLog(reader io.ReadCloser) error {
-	cache := ""
-	buf := make([]byte, 1024, 1024)
-	for {
-		num, err := reader.Read(buf)
-		if err != nil {
-			if err == io.EOF || strings.Contains(err.Error(), "closed") {
-				err = nil
-			}
-			return err
+// mergeXattrs 将tar包中的扩展属性合并到描述文件中，描述文件中的同名属性优先
+func mergeXattrs(description *models.Description, xattrs map[string]map[string]string) {
+	for i, v := range description.Files {
+		if fileType(v) != models.FileRegular {
+			continue
 		}
-		if num > 0 {
-			oByte := buf[:num]
-			oSlice := strings.Split(string(oByte), "\n")
-			line := strings.Join(oSlice[:len(oSlice)-1], "\n")
-			fmt.Printf("%s%s\n", cache, line)
-			cache = oSlice[len(oSlice)-1]
+		for k, value := range xattrs[path.Clean(v.Filename)] {
+			if _, ok := v.Xattrs[k]; ok {
+				continue
+			}
+			if description.Files[i].Xattrs == nil {
+				description.Files[i].Xattrs = make(map[string]string)
+			}
+			description.Files[i].Xattrs[k] = value
 		}
 	}
 }
 
-// 执行文件
-func execute(script string) error {
-
-	// 检测脚本是否有可执行权限
-	if fileInfo, err := os.Stat(script); err != nil {
+// onRollback 回滚完成后执行升级包中的回滚脚本
+func (core *Core) onRollback(tx *transaction, env *scriptEnv, scripts map[string][]models.Script) {
+	tx.afterRollback = func() error {
+		_, err := core.runScripts(env, scripts[models.ScriptRollback])
 		return err
-	} else if uint32(fileInfo.Mode().Perm()&os.FileMode(73)) != uint32(73) {
-		file, err := os.Open(script)
+	}
+}
+
+// onCommit 提交后更新数据库并清理缓存
+// 升级时保存配置文件记录和卸载脚本，并写入包的记录；卸载时删除包的记录；修复时不修改数据库
+func (core *Core) onCommit(tx *transaction) {
+	tx.afterCommit = func() error {
+		j := tx.journal
+		switch j.Operation {
+		case models.OperationRemove:
+			if err := core.forgetPackage(j.Record); err != nil {
+				return err
+			}
+			return core.gcObjects()
+		case models.OperationRepair:
+			return nil
+		}
+
+		if err := core.saveConffiles(j.Conffiles); err != nil {
+			return err
+		}
+		if j.Record == nil {
+			return nil
+		}
+		if err := saveRemoveScripts(j.Record, j.Package); err != nil {
+			return err
+		}
+		if err := core.recordCreated(j.Record, tx.entries); err != nil {
+			return err
+		}
+		previous, err := core.recordPackage(j.Record, j.Removed)
 		if err != nil {
 			return err
 		}
-		if err = file.Chmod(fileInfo.Mode() | os.FileMode(73)); err != nil {
-			log.Println("chmod file mode fail", err)
+		if previous != nil && previous.ScriptDir != "" && previous.ScriptDir != j.Record.ScriptDir {
+			if err = os.RemoveAll(previous.ScriptDir); err != nil {
+				return err
+			}
 		}
-		file.Close()
+		return core.gcObjects()
 	}
+}
 
-	cmd := exec.Command("sh", "-c", script)
-
-	stdout, _ := cmd.StdoutPipe()
-	stderr, _ := cmd.StderrPipe()
-
-	if err := cmd.Start(); err != nil {
-		log.Printf("Error starting command: %s......", err.Error())
-		return err
+// finish 依次执行修改文件之后的各类脚本并提交事务，按脚本的失败策略决定是否回滚
+func (core *Core) finish(tx *transaction, env *scriptEnv, scripts map[string][]models.Script, types ...string) error {
+	for _, typ := range types {
+		if policy, err := core.runScripts(env, scripts[typ]); err != nil {
+			if policy == models.OnFailureRollback {
+				return tx.abort(err)
+			}
+			// 文件已安装，提交失败时数据库等记录可能不完整，两个错误都需要返回
+			if cerr := tx.commit(); cerr != nil {
+				log.Println("commit fail", cerr)
+				return fmt.Errorf("%w; commit fail: %v", err, cerr)
+			}
+			return err
+		}
 	}
 
-	go asyncLog(stdout)
-	go asyncLog(stderr)
-
-	if err := cmd.Wait(); err != nil {
-		log.Printf("Error waiting for command execution: %s......", err.Error())
-		return err
+	if err := tx.journal.step(stepPostinstalled); err != nil {
+		return tx.abort(err)
 	}
 
-	return nil
+	return tx.commit()
 }
@@ -0,0 +1,327 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/ruixiaoedu/ota/models"
+	"github.com/ruixiaoedu/ota/utils"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// dbPath 已安装包数据库路径
+func (core *Core) dbPath() string {
+	return path.Join(core.stateDir, "installed.json")
+}
+
+// packages 读取所有已安装的包
+func (core *Core) packages() ([]models.Package, error) {
+	bs, err := ioutil.ReadFile(core.dbPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var pkgs []models.Package
+	if err = json.Unmarshal(bs, &pkgs); err != nil {
+		return nil, fmt.Errorf("invalid package database %s: %v", core.dbPath(), err)
+	}
+	return pkgs, nil
+}
+
+// savePackages 原子地写入数据库
+func (core *Core) savePackages(pkgs []models.Package) error {
+	bs, err := json.MarshalIndent(pkgs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(core.stateDir, 0755); err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(core.dbPath(), bytes.NewReader(bs), nil)
+}
+
+// installedPackage 查找安装根目录下的已安装包，未安装时返回空
+func (core *Core) installedPackage(name, root string) (*models.Package, error) {
+	pkgs, err := core.packages()
+	if err != nil {
+		return nil, err
+	}
+	for i := range pkgs {
+		if pkgs[i].Name == name && pkgs[i].Root == root {
+			return &pkgs[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// previousVersion 安装根目录下已安装的版本，数据库中没有时从升级记录中查找
+func (core *Core) previousVersion(name, root string) string {
+	if pkg, err := core.installedPackage(name, root); err == nil && pkg != nil {
+		return pkg.Version
+	}
+	return core.lastVersion(name, root)
+}
+
+// checkOwnership 检查描述文件中的路径是否属于安装根目录下的其他包，目录可以共享，
+// 删除的路径及其下的内容不能属于其他包，force时强制接管或删除
+func (core *Core) checkOwnership(description *models.Description, root string, force bool) error {
+	pkgs, err := core.packages()
+	if err != nil {
+		return err
+	}
+
+	// 删除的路径连同其下的内容，不能包括其他包拥有的路径，包括目录
+	owners := make(map[string]string)
+	for _, pkg := range pkgs {
+		if pkg.Root != root || pkg.Name == description.Name {
+			continue
+		}
+		for _, f := range pkg.Files {
+			if underAny(f.Path, description.Remove) {
+				if !force {
+					return fmt.Errorf("%s is owned by package %s and cannot be removed", f.Path, pkg.Name)
+				}
+				log.Printf("%s is owned by package %s, removing", f.Path, pkg.Name)
+			}
+			if f.Type != models.FileDir {
+				owners[f.Path] = pkg.Name
+			}
+		}
+	}
+
+	for _, v := range description.Files {
+		if fileType(v) == models.FileDir {
+			continue
+		}
+		if owner, ok := owners[v.Path]; ok {
+			if !force {
+				return fmt.Errorf("%s is owned by package %s", v.Path, owner)
+			}
+			log.Printf("%s is owned by package %s, taking over", v.Path, owner)
+		}
+	}
+	return nil
+}
+
+// newPackageRecord 根据描述文件生成已安装包的记录，dir为解压后的升级包目录，scriptDir为卸载脚本的保存目录
+func newPackageRecord(dir, root, scriptDir string, description *models.Description) (*models.Package, error) {
+	record := &models.Package{
+		Name:        description.Name,
+		Version:     description.Version,
+		Root:        root,
+		InstalledAt: time.Now(),
+		ScriptDir:   scriptDir,
+	}
+
+	for _, v := range description.Scripts {
+		if v.Type == models.ScriptPreremove || v.Type == models.ScriptPostremove {
+			record.Scripts = append(record.Scripts, v)
+		}
+	}
+
+	for _, v := range description.Files {
+		attrs, err := parseFileAttrs(v)
+		if err != nil {
+			return nil, err
+		}
+
+		f := models.InstalledFile{
+			Path:     v.Path,
+			Type:     fileType(v),
+			Target:   v.Target,
+			Conffile: v.Conffile,
+			Mirror:   v.Mirror,
+			Mode:     v.Mode,
+			Xattrs:   v.Xattrs,
+		}
+		if attrs.uid != -1 {
+			f.Uid = &attrs.uid
+		}
+		if attrs.gid != -1 {
+			f.Gid = &attrs.gid
+		}
+		if f.Type == models.FileRegular {
+			source, err := os.Open(path.Join(dir, v.Filename))
+			if err != nil {
+				return nil, err
+			}
+			f.Sha256, err = utils.Sha256FromReader(source)
+			source.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+		record.Files = append(record.Files, f)
+	}
+	return record, nil
+}
+
+// saveRemoveScripts 将卸载脚本从升级包目录复制到保存目录
+func saveRemoveScripts(record *models.Package, dir string) error {
+	if err := os.MkdirAll(record.ScriptDir, 0755); err != nil {
+		return err
+	}
+	for _, v := range record.Scripts {
+		if v.Filename == "" {
+			continue
+		}
+		filename := filepath.Join(record.ScriptDir, v.Filename)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return err
+		}
+		if err := utils.CopyFile(filepath.Join(dir, v.Filename), filename); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordPackage 将安装完成的包写入数据库，返回被替换的旧记录
+// 同一安装根目录下，被删除的路径和被强制接管的路径从其他包中移除
+func (core *Core) recordPackage(record *models.Package, removed []string) (*models.Package, error) {
+	pkgs, err := core.packages()
+	if err != nil {
+		return nil, err
+	}
+
+	owned := make(map[string]struct{})
+	for _, f := range record.Files {
+		if f.Type != models.FileDir {
+			owned[f.Path] = struct{}{}
+		}
+	}
+
+	var previous *models.Package
+	var result []models.Package
+	for i, pkg := range pkgs {
+		if pkg.Root != record.Root {
+			result = append(result, pkg)
+			continue
+		}
+		if pkg.Name == record.Name {
+			previous = &pkgs[i]
+			continue
+		}
+
+		files := pkg.Files[:0]
+		for _, f := range pkg.Files {
+			if _, ok := owned[f.Path]; ok || underAny(f.Path, removed) {
+				continue
+			}
+			files = append(files, f)
+		}
+		pkg.Files = files
+		result = append(result, pkg)
+	}
+	result = append(result, *record)
+
+	return previous, core.savePackages(result)
+}
+
+// forgetPackage 从数据库中删除已卸载的包及其卸载脚本
+func (core *Core) forgetPackage(record *models.Package) error {
+	pkgs, err := core.packages()
+	if err != nil {
+		return err
+	}
+
+	var result []models.Package
+	for _, pkg := range pkgs {
+		if pkg.Name != record.Name || pkg.Root != record.Root {
+			result = append(result, pkg)
+		}
+	}
+	if err = core.savePackages(result); err != nil {
+		return err
+	}
+
+	if record.ScriptDir != "" {
+		return os.RemoveAll(record.ScriptDir)
+	}
+	return nil
+}
+
+// recordCreated 在记录中加入本次安装新建的上级目录和另存的配置文件，
+// 并保留之前版本记录的仍然存在的路径
+func (core *Core) recordCreated(record *models.Package, entries []backupEntry) error {
+	previous, err := core.installedPackage(record.Name, record.Root)
+	if err != nil {
+		return err
+	}
+
+	declared := make(map[string]struct{})
+	for _, f := range record.Files {
+		declared[f.Path] = struct{}{}
+	}
+	seen := make(map[string]struct{})
+	add := func(list *[]string, name string) {
+		if _, ok := declared[name]; ok {
+			return
+		}
+		if _, ok := seen[name]; ok {
+			return
+		}
+		seen[name] = struct{}{}
+		*list = append(*list, name)
+	}
+
+	if previous != nil {
+		for _, v := range previous.Dirs {
+			if utils.FileExist(v) {
+				add(&record.Dirs, v)
+			}
+		}
+		for _, v := range previous.NewConffiles {
+			if utils.FileExist(v) {
+				add(&record.NewConffiles, v)
+			}
+		}
+	}
+	for _, e := range entries {
+		switch {
+		case e.Dir:
+			add(&record.Dirs, e.Path)
+		case strings.HasSuffix(e.Path, conffileSuffix) && utils.FileExist(e.Path):
+			add(&record.NewConffiles, e.Path)
+		}
+	}
+	return nil
+}
+
+// otherPaths 同一安装根目录下其他包拥有的路径
+func (core *Core) otherPaths(name, root string) ([]string, error) {
+	pkgs, err := core.packages()
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, pkg := range pkgs {
+		if pkg.Root != root || pkg.Name == name {
+			continue
+		}
+		for _, f := range pkg.Files {
+			paths = append(paths, f.Path)
+		}
+	}
+	return paths, nil
+}
+
+// underAny name是否为paths中的某个路径或其下的路径
+func underAny(name string, paths []string) bool {
+	for _, v := range paths {
+		v = filepath.Clean(v)
+		if name == v || strings.HasPrefix(name, v+"/") {
+			return true
+		}
+	}
+	return false
+}
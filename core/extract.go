@@ -0,0 +1,341 @@
+package core
+
+import (
+	"archive/tar"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/ruixiaoedu/ota/models"
+	"github.com/ruixiaoedu/ota/utils"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// archive 解压后的tar包中，描述文件之外的信息
+type archive struct {
+	xattrs  map[string]map[string]string // 按文件名记录的扩展属性
+	entries []archiveEntry               // 等待描述文件声明后才创建的条目
+}
+
+// archiveEntry 普通文件和目录之外的tar包条目
+type archiveEntry struct {
+	name     string // 清理后的相对路径
+	typ      string // 条目类型
+	linkname string // 链接目标
+	mode     int64
+	devmajor int64
+	devminor int64
+}
+
+// payloadFile 描述文件中列出的升级包文件的校验值
+type payloadFile struct {
+	md5    string
+	sha256 string
+	size   int64
+}
+
+// extract 严格地将tar包解压到dir
+// 名称不能为绝对路径、不能包含..、不能重复；只创建普通文件和目录，
+// 其他类型的条目记录下来，等验证描述文件后按其声明创建
+// 写入每个条目之前检查大小限制和dir所在文件系统的剩余空间
+// manifest不为空时，描述文件和签名必须是tar包的前两个条目，由manifest读取并验签，
+// 之后的普通文件必须在描述文件中列出，边解压边校验
+func extract(tr *tar.Reader, dir string, limits archiveLimits, manifest func(dir string) (*models.Description, error)) (*archive, error) {
+	ar := &archive{xattrs: make(map[string]map[string]string)}
+	names := make(map[string]struct{})
+	var payload map[string][]payloadFile
+
+	free, err := freeSpace(dir)
+	if err != nil {
+		return nil, err
+	}
+	var total int64
+
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+
+		// tar -C dir -czf pkg.tgz . 生成的包以./开头，解压目录已存在
+		if hdr.Typeflag == tar.TypeDir && hdr.Name != "" && path.Clean(hdr.Name) == "." {
+			continue
+		}
+
+		name, err := entryName(hdr.Name)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := names[name]; ok {
+			return nil, fmt.Errorf("archive entry %s is duplicated", hdr.Name)
+		}
+		names[name] = struct{}{}
+
+		// 描述文件和签名之后的第一个条目之前验证描述文件
+		if manifest != nil && payload == nil {
+			switch {
+			case len(names) == 1:
+				if name != "ota-description.json" {
+					return nil, fmt.Errorf("archive entry %s is before ota-description.json", hdr.Name)
+				}
+			case len(names) == 2 && name == "ota-description.sig":
+			default:
+				description, err := manifest(dir)
+				if err != nil {
+					return nil, err
+				}
+				payload = payloadFiles(description)
+			}
+		}
+
+		if limits.entries > 0 && len(names) > limits.entries {
+			return nil, fmt.Errorf("package has more than %d entries", limits.entries)
+		}
+		if limits.entry > 0 && hdr.Size > limits.entry {
+			return nil, fmt.Errorf("archive entry %s is %d bytes, larger than the limit of %d bytes", hdr.Name, hdr.Size, limits.entry)
+		}
+		total += hdr.Size
+		if limits.total > 0 && total > limits.total {
+			return nil, fmt.Errorf("package is larger than the limit of %d bytes when uncompressed", limits.total)
+		}
+		if total > free {
+			return nil, fmt.Errorf("not enough space to extract package in %s: need at least %d bytes, %d available", dir, total, free)
+		}
+
+		if m := archiveXattrs(hdr.PAXRecords); m != nil {
+			ar.xattrs[name] = m
+		}
+
+		entry := archiveEntry{name: name, linkname: hdr.Linkname, mode: hdr.Mode, devmajor: hdr.Devmajor, devminor: hdr.Devminor}
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+			if payload == nil {
+				err = extractFile(tr, dir, name, hdr.Mode)
+			} else {
+				err = extractPayload(tr, dir, name, hdr, payload[name])
+			}
+			if err != nil {
+				return nil, err
+			}
+			continue
+		case tar.TypeDir:
+			if err = os.MkdirAll(filepath.Join(dir, name), 0755); err != nil {
+				return nil, err
+			}
+			continue
+		case tar.TypeSymlink:
+			entry.typ = models.FileSymlink
+		case tar.TypeLink:
+			entry.typ = models.FileHardlink
+		case tar.TypeChar:
+			entry.typ = models.EntryChar
+		case tar.TypeBlock:
+			entry.typ = models.EntryBlock
+		case tar.TypeFifo:
+			entry.typ = models.EntryFifo
+		default:
+			return nil, fmt.Errorf("archive entry %s has unsupported type %q", hdr.Name, hdr.Typeflag)
+		}
+		if len(payload[name]) > 0 {
+			return nil, fmt.Errorf("archive entry %s is a %s, but the description uses it as a regular file", hdr.Name, entry.typ)
+		}
+		ar.entries = append(ar.entries, entry)
+	}
+
+	// 只包含描述文件和签名的升级包
+	if manifest != nil && payload == nil {
+		if _, err := manifest(dir); err != nil {
+			return nil, err
+		}
+	}
+	return ar, nil
+}
+
+// payloadFiles 返回描述文件中按文件名记录的普通文件和脚本文件的校验值
+func payloadFiles(description *models.Description) map[string][]payloadFile {
+	payload := make(map[string][]payloadFile)
+	for _, v := range description.Files {
+		if fileType(v) == models.FileRegular && v.Filename != "" {
+			name := path.Clean(v.Filename)
+			payload[name] = append(payload[name], payloadFile{md5: v.Md5, sha256: v.Sha256, size: v.Size})
+		}
+	}
+	for _, v := range description.Scripts {
+		if v.Content == "" && v.Filename != "" {
+			name := path.Clean(v.Filename)
+			payload[name] = append(payload[name], payloadFile{md5: v.Md5, sha256: v.Sha256})
+		}
+	}
+	return payload
+}
+
+// checkListed 检查解压目录中的普通文件是否都在描述文件中列出
+func checkListed(dir string, description *models.Description) error {
+	payload := payloadFiles(description)
+	return filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "ota-description.json" || rel == "ota-description.sig" {
+			return nil
+		}
+		if _, ok := payload[rel]; !ok {
+			return fmt.Errorf("archive entry %s is not listed in the description", rel)
+		}
+		return nil
+	})
+}
+
+// extractPayload 解压描述文件中列出的文件，同时计算校验值，不符时立即拒绝
+func extractPayload(reader io.Reader, dir, name string, hdr *tar.Header, expects []payloadFile) error {
+	if len(expects) == 0 {
+		return fmt.Errorf("archive entry %s is not listed in the description", hdr.Name)
+	}
+	for _, v := range expects {
+		if v.size > 0 && v.size != hdr.Size {
+			return fmt.Errorf("archive entry %s is %d bytes, expect %d", hdr.Name, hdr.Size, v.size)
+		}
+	}
+
+	md5sum, sha256sum := md5.New(), sha256.New()
+	if err := extractFile(io.TeeReader(reader, io.MultiWriter(md5sum, sha256sum)), dir, name, hdr.Mode); err != nil {
+		return err
+	}
+	for _, v := range expects {
+		if v.md5 != "" && hex.EncodeToString(md5sum.Sum(nil)) != v.md5 {
+			return fmt.Errorf("%s md5 is not right", hdr.Name)
+		}
+		if v.sha256 != "" && hex.EncodeToString(sha256sum.Sum(nil)) != v.sha256 {
+			return fmt.Errorf("%s sha256 is not right", hdr.Name)
+		}
+	}
+	return nil
+}
+
+// entryName 检查tar包条目的名称，返回清理后的相对路径
+func entryName(name string) (string, error) {
+	if name == "" || path.IsAbs(name) || strings.Contains(name, "\\") {
+		return "", fmt.Errorf("archive entry %q has an invalid name", name)
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("archive entry %q escapes the package directory", name)
+		}
+	}
+	cleaned := path.Clean(name)
+	if cleaned == "." {
+		return "", fmt.Errorf("archive entry %q has an invalid name", name)
+	}
+	return cleaned, nil
+}
+
+// extractFile 创建普通文件，此时解压目录中还没有符号链接，路径不会逃逸
+func extractFile(reader io.Reader, dir, name string, mode int64) error {
+	filename := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, reader); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Chmod(os.FileMode(mode).Perm() | 0444); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// createEntries 创建描述文件声明的其他类型的条目，devices为是否允许设备文件
+// 符号链接的目标必须为不含..的相对路径，硬链接只能指向包中的普通文件
+func createEntries(dir string, description *models.Description, entries []archiveEntry, devices bool) error {
+	declared := make(map[string]bool)
+	for _, v := range description.ArchiveTypes {
+		declared[v] = true
+	}
+
+	payload := payloadFiles(description)
+	for _, v := range entries {
+		// 普通文件和脚本只能来自普通文件条目，读取管道或设备会阻塞或读到设备内容
+		if _, ok := payload[v.name]; ok {
+			return fmt.Errorf("archive entry %s is a %s, but the description uses it as a regular file", v.name, v.typ)
+		}
+		if !declared[v.typ] {
+			return fmt.Errorf("archive entry %s has type %s, which is not declared in archive_types", v.name, v.typ)
+		}
+		if (v.typ == models.EntryChar || v.typ == models.EntryBlock) && !devices {
+			return fmt.Errorf("archive entry %s is a device, which is not allowed by policy", v.name)
+		}
+
+		// 已创建的符号链接只在解压目录内解析
+		filename, err := utils.ResolveInRoot(dir, v.name)
+		if err != nil {
+			return err
+		}
+		if err = os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return err
+		}
+
+		switch v.typ {
+		case models.FileSymlink:
+			if err = checkLinkname(v.linkname); err != nil {
+				return fmt.Errorf("archive entry %s: %v", v.name, err)
+			}
+			err = os.Symlink(v.linkname, filename)
+		case models.FileHardlink:
+			target, terr := entryName(v.linkname)
+			if terr != nil {
+				return terr
+			}
+			if target, terr = utils.ResolveInRoot(dir, target); terr != nil {
+				return terr
+			}
+			if fi, serr := os.Lstat(target); serr != nil || !fi.Mode().IsRegular() {
+				return fmt.Errorf("archive entry %s links to %s, which is not a regular file in the package", v.name, v.linkname)
+			}
+			err = os.Link(target, filename)
+		case models.EntryFifo:
+			err = unix.Mkfifo(filename, uint32(os.FileMode(v.mode).Perm()))
+		case models.EntryChar:
+			err = unix.Mknod(filename, unix.S_IFCHR|uint32(os.FileMode(v.mode).Perm()), int(unix.Mkdev(uint32(v.devmajor), uint32(v.devminor))))
+		case models.EntryBlock:
+			err = unix.Mknod(filename, unix.S_IFBLK|uint32(os.FileMode(v.mode).Perm()), int(unix.Mkdev(uint32(v.devmajor), uint32(v.devminor))))
+		}
+		if err != nil {
+			return fmt.Errorf("create archive entry %s fail: %v", v.name, err)
+		}
+	}
+	return nil
+}
+
+// checkLinkname 检查包中符号链接的目标
+func checkLinkname(linkname string) error {
+	if linkname == "" || path.IsAbs(linkname) {
+		return errors.New("symlink target must be a relative path")
+	}
+	for _, part := range strings.Split(linkname, "/") {
+		if part == ".." {
+			return errors.New("symlink target cannot contain ..")
+		}
+	}
+	return nil
+}
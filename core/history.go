@@ -0,0 +1,117 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"github.com/ruixiaoedu/ota/models"
+	"os"
+	"path"
+	"time"
+)
+
+// historyMessageSize 升级记录中错误信息的最大长度
+const historyMessageSize = 16 << 10
+
+// recordHistory 追加一条升级记录，写入失败时仍返回该记录
+func (core *Core) recordHistory(j *journal, recovered bool, result error) (*models.History, error) {
+	h := &models.History{
+		Id:        j.Id,
+		Time:      time.Now(),
+		Name:      j.Name,
+		Version:   j.Version,
+		Root:      j.Root,
+		Operation: j.Operation,
+		Ok:        result == nil,
+		Recovered: recovered,
+		Warnings:  j.Warnings,
+	}
+	if result != nil {
+		h.Message = result.Error()
+		if len(h.Message) > historyMessageSize {
+			h.Message = h.Message[:historyMessageSize] + "..."
+		}
+		var installErr *InstallError
+		if errors.As(result, &installErr) {
+			h.RolledBack = installErr.RolledBack()
+		}
+	}
+
+	bs, err := json.Marshal(h)
+	if err != nil {
+		return h, err
+	}
+
+	if err = os.MkdirAll(core.stateDir, 0755); err != nil {
+		return h, err
+	}
+	f, err := os.OpenFile(path.Join(core.stateDir, "history.log"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return h, err
+	}
+	if _, err = f.Write(append(bs, '\n')); err != nil {
+		f.Close()
+		return h, err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return h, err
+	}
+	return h, f.Close()
+}
+
+// histories 读取所有升级记录
+func (core *Core) histories() []models.History {
+	f, err := os.Open(path.Join(core.stateDir, "history.log"))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	// 不限制行的长度，早期版本可能写入了很长的错误信息
+	var hs []models.History
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		var h models.History
+		if len(line) > 0 && json.Unmarshal(line, &h) == nil {
+			hs = append(hs, h)
+		}
+		if err != nil {
+			return hs
+		}
+	}
+}
+
+// lastVersion 从升级记录中查找包在安装根目录下最后一次成功安装的版本
+func (core *Core) lastVersion(name, root string) string {
+	version := ""
+	for _, h := range core.histories() {
+		if h.Ok && h.Name == name && historyRoot(h) == root && h.Operation != models.OperationRepair {
+			version = h.Version
+			if h.Operation == models.OperationRemove {
+				version = ""
+			}
+		}
+	}
+	return version
+}
+
+// historyRoot 升级记录的安装根目录，早期的记录没有根目录
+func historyRoot(h models.History) string {
+	if h.Root == "" {
+		return "/"
+	}
+	return h.Root
+}
+
+// lastUpdateId 最近一次升级的ID
+func (core *Core) lastUpdateId() string {
+	hs := core.histories()
+	for i := len(hs) - 1; i >= 0; i-- {
+		if hs[i].Id != "" {
+			return hs[i].Id
+		}
+	}
+	return ""
+}
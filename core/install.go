@@ -0,0 +1,426 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ruixiaoedu/ota/models"
+	"github.com/ruixiaoedu/ota/utils"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// fileType 描述文件中条目的类型，为空时为普通文件
+func fileType(v models.File) string {
+	if v.Type == "" {
+		return models.FileRegular
+	}
+	return v.Type
+}
+
+// resolvePaths 将描述文件中的安装路径、删除路径和硬链接目标解析为安装根目录下的路径
+// 非绝对路径的删除路径和硬链接目标保持不变，留给后续检查报错
+func resolvePaths(description *models.Description, root string) error {
+	var err error
+	for i, v := range description.Files {
+		if description.Files[i].Path, err = utils.ResolveInRoot(root, v.Path); err != nil {
+			return err
+		}
+		if fileType(v) == models.FileHardlink && filepath.IsAbs(v.Target) {
+			if description.Files[i].Target, err = utils.ResolveInRoot(root, v.Target); err != nil {
+				return err
+			}
+		}
+	}
+	for i, v := range description.Remove {
+		if filepath.IsAbs(v) {
+			if description.Remove[i], err = utils.ResolveInRoot(root, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkRemove 检查需要删除的路径
+func checkRemove(name string) error {
+	if !filepath.IsAbs(name) {
+		return fmt.Errorf("remove path %s must be absolute", name)
+	}
+	if filepath.Clean(name) == "/" {
+		return errors.New("cannot remove /")
+	}
+	return nil
+}
+
+// removing name是否在升级时被删除
+func removing(description *models.Description, name string) bool {
+	return underAny(filepath.Clean(name), description.Remove)
+}
+
+// checkEntry 检查条目是否有效，以及是否与已有路径的类型冲突
+// 升级时先删除的路径不检查类型冲突
+func checkEntry(v models.File, description *models.Description) error {
+	typ := fileType(v)
+	switch typ {
+	case models.FileRegular:
+	case models.FileDir:
+	case models.FileSymlink:
+		if v.Target == "" {
+			return fmt.Errorf("%s: symlink target is empty", v.Path)
+		}
+	case models.FileHardlink:
+		if !filepath.IsAbs(v.Target) {
+			return fmt.Errorf("%s: hardlink target must be an absolute path", v.Path)
+		}
+	default:
+		return errors.New("无效的文件type")
+	}
+
+	if v.Conffile && typ != models.FileRegular {
+		return fmt.Errorf("%s: conffile is only valid for regular files", v.Path)
+	}
+	if len(v.Xattrs) > 0 && typ == models.FileHardlink {
+		return fmt.Errorf("%s: xattrs are not valid for hardlinks", v.Path)
+	}
+	if v.Size < 0 || (v.Size > 0 && typ != models.FileRegular) {
+		return fmt.Errorf("%s: size is only valid for regular files", v.Path)
+	}
+	if v.Mirror && typ != models.FileDir {
+		return fmt.Errorf("%s: mirror is only valid for directories", v.Path)
+	}
+
+	if _, err := parseFileAttrs(v); err != nil {
+		return err
+	}
+	if removing(description, v.Path) {
+		return nil
+	}
+
+	// 上级路径不是目录时，留给安装时报错并回滚
+	fi, err := os.Lstat(v.Path)
+	if os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// 目录与其他类型之间不能互相替换，普通文件和链接之间可以
+	var existing string
+	switch {
+	case fi.IsDir():
+		existing = models.FileDir
+	case fi.Mode().IsRegular():
+		existing = models.FileRegular
+	case fi.Mode()&os.ModeSymlink != 0:
+		existing = models.FileSymlink
+	default:
+		return fmt.Errorf("%s: existing special file cannot be replaced by %s", v.Path, typ)
+	}
+	if (existing == models.FileDir) != (typ == models.FileDir) {
+		return fmt.Errorf("%s: existing %s cannot be replaced by %s", v.Path, existing, typ)
+	}
+	return nil
+}
+
+// installOrder 安装顺序：目录由浅到深，然后是普通文件、硬链接，最后是符号链接
+func installOrder(files []models.File) []models.File {
+	rank := map[string]int{
+		models.FileDir:      0,
+		models.FileRegular:  1,
+		models.FileHardlink: 2,
+		models.FileSymlink:  3,
+	}
+
+	sorted := append([]models.File(nil), files...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ri, rj := rank[fileType(sorted[i])], rank[fileType(sorted[j])]
+		if ri != rj {
+			return ri < rj
+		}
+		if ri == 0 {
+			return strings.Count(path.Clean(sorted[i].Path), "/") < strings.Count(path.Clean(sorted[j].Path), "/")
+		}
+		return false
+	})
+	return sorted
+}
+
+// install 删除需要删除的路径，按顺序安装所有条目，清理镜像目录，最后校验安装结果
+// conffiles为上次安装的配置文件的SHA256，用于判断配置文件是否被本地修改过
+func install(tx *transaction, dir string, description *models.Description, conffiles map[string]string, others []string) error {
+	for _, v := range description.Remove {
+		if err := removePath(tx, filepath.Clean(v)); err != nil {
+			return err
+		}
+	}
+
+	// 普通文件实际的安装位置，配置文件可能另存
+	dests := make(map[string]string)
+	for _, v := range installOrder(description.Files) {
+		attrs, err := parseFileAttrs(v)
+		if err != nil {
+			return err
+		}
+
+		dest := v.Path
+		if v.Conffile {
+			if dest, err = installConffile(tx, path.Join(dir, v.Filename), v.Path, conffiles); err != nil {
+				return err
+			}
+			if dest == "" {
+				// 已有文件与新版本相同，只更新属性
+				dests[v.Path] = v.Path
+				if err = tx.backup(v.Path); err == nil {
+					err = attrs.applyPath(v.Path, false)
+				}
+				if err != nil {
+					return fmt.Errorf("install %s fail: %v", v.Path, err)
+				}
+				continue
+			}
+		}
+		dests[v.Path] = dest
+
+		if err = tx.backup(dest); err != nil {
+			return err
+		}
+
+		switch fileType(v) {
+		case models.FileDir:
+			err = installDir(dest, attrs)
+		case models.FileSymlink:
+			if err = utils.SymlinkAtomic(v.Target, dest); err == nil {
+				err = attrs.applyPath(dest, true)
+			}
+		case models.FileHardlink:
+			err = utils.LinkAtomic(v.Target, dest)
+		default:
+			if err = installFile(path.Join(dir, v.Filename), dest, attrs); err == nil {
+				err = attrs.applyXattrs(dest)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("install %s fail: %v", dest, err)
+		}
+
+		if err = tx.journal.commit(dest); err != nil {
+			return err
+		}
+	}
+
+	if err := mirror(tx, description.Files, dests, others); err != nil {
+		return err
+	}
+
+	// 校验已安装的条目
+	for _, v := range description.Files {
+		if dest, ok := dests[v.Path]; ok {
+			v.Path = dest
+		}
+		if err := verifyEntry(v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// installConffile 计算配置文件的安装位置，并在安装日志中记录新版本的SHA256和警告
+func installConffile(tx *transaction, source, name string, conffiles map[string]string) (string, error) {
+	f, err := os.Open(source)
+	if err != nil {
+		return "", err
+	}
+	hash, err := utils.Sha256FromReader(f)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+
+	dest, err := conffileDest(name, hash, conffiles)
+	if err != nil {
+		return "", err
+	}
+
+	if err = tx.journal.conffile(name, hash); err != nil {
+		return "", err
+	}
+	if dest != "" && dest != name {
+		tx.warn(conffileWarning(name))
+	}
+	return dest, nil
+}
+
+// removePath 删除路径，目录先删除其中的内容，每个路径删除前都会备份
+func removePath(tx *transaction, name string) error {
+	fi, err := os.Lstat(name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if fi.IsDir() {
+		entries, err := ioutil.ReadDir(name)
+		if err != nil {
+			return err
+		}
+		for _, v := range entries {
+			if err = removePath(tx, filepath.Join(name, v.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 子路径先于目录备份，回滚时按相反顺序先重建目录
+	if err = tx.backup(name); err != nil {
+		return err
+	}
+	if err = os.Remove(name); err != nil {
+		return fmt.Errorf("remove %s fail: %v", name, err)
+	}
+	return utils.SyncDir(filepath.Dir(name))
+}
+
+// mirror 删除镜像目录中不属于升级包的内容，dests为条目实际的安装位置，others为其他包拥有的路径
+func mirror(tx *transaction, files []models.File, dests map[string]string, others []string) error {
+	// 升级包中的路径、其他包拥有的路径及其上级目录都需要保留
+	keep := make(map[string]struct{})
+	paths := append([]string(nil), others...)
+	for _, v := range files {
+		paths = append(paths, v.Path, dests[v.Path])
+	}
+	for _, name := range paths {
+		for name = filepath.Clean(name); name != "/" && name != "."; name = filepath.Dir(name) {
+			keep[name] = struct{}{}
+		}
+	}
+
+	var prune func(dir string) error
+	prune = func(dir string) error {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, v := range entries {
+			name := filepath.Join(dir, v.Name())
+			if _, ok := keep[name]; !ok {
+				err = removePath(tx, name)
+			} else if v.IsDir() {
+				err = prune(name)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, v := range files {
+		if v.Mirror {
+			if err := prune(filepath.Clean(v.Path)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// installFile 原子地安装普通文件
+func installFile(source, destination string, attrs *fileAttrs) error {
+	f, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return utils.WriteFileAtomic(destination, f, attrs.apply)
+}
+
+// installDir 创建目录并设置属性，目录已存在时只设置属性
+func installDir(name string, attrs *fileAttrs) error {
+	if err := os.MkdirAll(name, 0755); err != nil {
+		return err
+	}
+	if err := attrs.applyPath(name, false); err != nil {
+		return err
+	}
+	return utils.SyncDir(filepath.Dir(name))
+}
+
+// verifyEntry 校验已安装的条目
+func verifyEntry(v models.File) error {
+	switch fileType(v) {
+	case models.FileDir:
+		fi, err := os.Lstat(v.Path)
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("%s is not a directory", v.Path)
+		}
+	case models.FileSymlink:
+		target, err := os.Readlink(v.Path)
+		if err != nil {
+			return err
+		}
+		if target != v.Target {
+			return fmt.Errorf("%s links to %s, not %s", v.Path, target, v.Target)
+		}
+	case models.FileHardlink:
+		fi, err := os.Lstat(v.Path)
+		if err != nil {
+			return err
+		}
+		ti, err := os.Lstat(v.Target)
+		if err != nil {
+			return err
+		}
+		if !os.SameFile(fi, ti) {
+			return fmt.Errorf("%s is not a hardlink of %s", v.Path, v.Target)
+		}
+	default:
+		return verifyFile(v.Path, v.Md5, v.Sha256)
+	}
+	return nil
+}
+
+// verifyFile 校验文件的MD5和SHA256
+func verifyFile(filename, md5, sha256 string) error {
+	if md5 != "" {
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		hex, err := utils.Md5FromReader(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		if hex != md5 {
+			return fmt.Errorf("%s md5 is not right", filename)
+		}
+	}
+
+	if sha256 != "" {
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		hex, err := utils.Sha256FromReader(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		if hex != sha256 {
+			return fmt.Errorf("%s sha256 is not right", filename)
+		}
+	}
+
+	return nil
+}
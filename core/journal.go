@@ -0,0 +1,230 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/ruixiaoedu/ota/models"
+	"github.com/ruixiaoedu/ota/utils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 安装步骤
+const (
+	stepExtracted     = "extracted"     // 升级包已解压
+	stepVerified      = "verified"      // 描述文件和文件校验通过
+	stepPreinstalled  = "preinstalled"  // 预执行脚本已执行
+	stepInstalling    = "installing"    // 正在复制文件
+	stepInstalled     = "installed"     // 文件已全部复制并校验
+	stepPostinstalled = "postinstalled" // 完成执行脚本和校验脚本已执行
+)
+
+// journal 预写式安装日志，每一步在执行前后落盘，用于异常中断后的恢复。
+// 步骤等少量信息原子地整体重写，包记录只写入一次，备份和提交记录追加写入
+type journal struct {
+	Id        string            `json:"id"`        // 升级ID
+	Name      string            `json:"name"`      // 包名称
+	Version   string            `json:"version"`   // 包版本
+	Package   string            `json:"package"`   // 解压目录
+	Root      string            `json:"root"`      // 安装根目录
+	Operation string            `json:"operation"` // 操作类型，为空时为升级
+	Force     bool              `json:"force"`     // 是否强制接管其他包拥有的路径
+	Step      string            `json:"step"`      // 当前步骤
+	Backups   []backupEntry     `json:"-"`         // 修改前的备份
+	Committed []string          `json:"-"`         // 已完成复制的文件
+	Conffiles map[string]string `json:"-"`         // 本次安装的配置文件的SHA256，提交后保存
+	Warnings  []string          `json:"warnings"`  // 安装过程中的警告
+	Record    *models.Package   `json:"-"`         // 安装完成后写入数据库的记录
+	Removed   []string          `json:"removed"`   // 升级时删除的路径，提交后从数据库中移除
+	StartedAt time.Time         `json:"started_at"`
+
+	path    string   // 日志文件路径
+	entries *os.File // 追加写入的记录文件
+}
+
+// journalEntry 追加写入的一条记录
+type journalEntry struct {
+	Backup   *backupEntry `json:"backup,omitempty"`   // 修改前的备份
+	Commit   string       `json:"commit,omitempty"`   // 已完成复制的文件
+	Conffile string       `json:"conffile,omitempty"` // 安装的配置文件
+	Sha256   string       `json:"sha256,omitempty"`   // 配置文件的SHA256
+}
+
+// newJournal 创建安装日志，按开始时间生成升级ID
+func newJournal(path, pkg, root string) *journal {
+	now := time.Now()
+	return &journal{
+		Id:        now.Format("20060102-150405.000"),
+		Package:   pkg,
+		Root:      root,
+		StartedAt: now,
+		path:      path,
+	}
+}
+
+// loadJournal 读取安装日志、包记录和追加的记录
+func loadJournal(path string) (*journal, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	j := &journal{path: path}
+	if err = json.Unmarshal(bs, j); err != nil {
+		return nil, err
+	}
+
+	if bs, err = ioutil.ReadFile(j.recordPath()); err == nil {
+		if err = json.Unmarshal(bs, &j.Record); err != nil {
+			return nil, fmt.Errorf("load package record fail: %v", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	bs, err = ioutil.ReadFile(j.entriesPath())
+	if os.IsNotExist(err) {
+		return j, nil
+	} else if err != nil {
+		return nil, err
+	}
+	// 最后一条没有换行符的记录写入时中断，对应的修改尚未进行
+	lines := bytes.Split(bs, []byte("\n"))
+	for _, line := range lines[:len(lines)-1] {
+		var e journalEntry
+		if err = json.Unmarshal(line, &e); err != nil {
+			return nil, fmt.Errorf("load journal entry fail: %v", err)
+		}
+		j.apply(e)
+	}
+	return j, nil
+}
+
+// recordPath 包记录文件路径
+func (j *journal) recordPath() string {
+	return strings.TrimSuffix(j.path, filepath.Ext(j.path)) + ".record.json"
+}
+
+// entriesPath 追加记录文件路径
+func (j *journal) entriesPath() string {
+	return strings.TrimSuffix(j.path, filepath.Ext(j.path)) + ".entries"
+}
+
+// step 记录完成的步骤
+func (j *journal) step(step string) error {
+	j.Step = step
+	return j.save()
+}
+
+// saveRecord 写入安装完成后写入数据库的记录，校验通过前只写入一次
+func (j *journal) saveRecord(record *models.Package) error {
+	bs, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err = utils.WriteFileAtomic(j.recordPath(), bytes.NewReader(bs), nil); err != nil {
+		return err
+	}
+	j.Record = record
+	return nil
+}
+
+// reset 清空上一次遗留的追加记录
+func (j *journal) reset() error {
+	if j.entries != nil {
+		j.entries.Close()
+		j.entries = nil
+	}
+	return j.open(os.O_TRUNC)
+}
+
+// open 打开追加记录文件
+func (j *journal) open(flag int) error {
+	f, err := os.OpenFile(j.entriesPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND|flag, 0600)
+	if err != nil {
+		return err
+	}
+	if err = utils.SyncDir(filepath.Dir(j.path)); err != nil {
+		f.Close()
+		return err
+	}
+	j.entries = f
+	return nil
+}
+
+// append 追加一条记录并落盘
+func (j *journal) append(e journalEntry) error {
+	if j.entries == nil {
+		if err := j.open(0); err != nil {
+			return err
+		}
+	}
+	bs, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err = j.entries.Write(append(bs, '\n')); err != nil {
+		return err
+	}
+	if err = j.entries.Sync(); err != nil {
+		return err
+	}
+	j.apply(e)
+	return nil
+}
+
+// apply 将记录合并到日志中
+func (j *journal) apply(e journalEntry) {
+	switch {
+	case e.Backup != nil:
+		j.Backups = append(j.Backups, *e.Backup)
+	case e.Commit != "":
+		j.Committed = append(j.Committed, e.Commit)
+	case e.Conffile != "":
+		if j.Conffiles == nil {
+			j.Conffiles = make(map[string]string)
+		}
+		j.Conffiles[e.Conffile] = e.Sha256
+	}
+}
+
+// backup 记录修改前的备份
+func (j *journal) backup(entry backupEntry) error {
+	return j.append(journalEntry{Backup: &entry})
+}
+
+// commit 记录已完成复制的文件
+func (j *journal) commit(name string) error {
+	return j.append(journalEntry{Commit: name})
+}
+
+// conffile 记录本次安装的配置文件
+func (j *journal) conffile(name, sha256 string) error {
+	return j.append(journalEntry{Conffile: name, Sha256: sha256})
+}
+
+// save 原子地写入日志
+func (j *journal) save() error {
+	bs, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(j.path, bytes.NewReader(bs), nil)
+}
+
+// remove 删除日志，安装结束。先删除日志本身，中断后不会再恢复
+func (j *journal) remove() error {
+	if j.entries != nil {
+		j.entries.Close()
+		j.entries = nil
+	}
+	for _, name := range []string{j.path, j.recordPath(), j.entriesPath()} {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
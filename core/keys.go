@@ -0,0 +1,180 @@
+package core
+
+import (
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/ruixiaoedu/ota/utils"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultKeyId   = "default" // keyfile中公钥的ID，未标注ID的签名使用此公钥验证
+	keyExpires     = "Expires" // 公钥PEM头中的过期时间，RFC3339格式
+	keyFileSuffix  = ".pem"    // 受信任公钥目录中的公钥文件后缀，文件名即为公钥ID
+	anyKey         = "*"       // 签名策略中表示任意受信任的公钥
+	policySeparate = ":"       // 签名策略中数量和公钥ID之间的分隔符
+)
+
+// trustedKey 受信任的公钥
+type trustedKey struct {
+	key     *rsa.PublicKey
+	expires time.Time // 过期时间，为零时不过期
+}
+
+// signatureRule 签名策略中的一条规则，需要keys中至少threshold个公钥的有效签名
+type signatureRule struct {
+	threshold int
+	keys      []string // 为空时为任意受信任的公钥
+}
+
+// loadKeys 加载受信任的公钥，keyfile中的公钥ID为default，dir中每个.pem文件的文件名为公钥ID
+func loadKeys(keyfile, dir string) (map[string]trustedKey, error) {
+	keys := make(map[string]trustedKey)
+	if keyfile != "" {
+		key, err := loadKey(keyfile)
+		if err != nil {
+			return nil, err
+		}
+		keys[defaultKeyId] = key
+	}
+	if dir == "" {
+		return keys, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"+keyFileSuffix))
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range files {
+		id := strings.TrimSuffix(filepath.Base(v), keyFileSuffix)
+		if _, ok := keys[id]; ok {
+			return nil, fmt.Errorf("key id %s is duplicated", id)
+		}
+		if keys[id], err = loadKey(v); err != nil {
+			return nil, fmt.Errorf("load key %s fail: %v", v, err)
+		}
+	}
+	return keys, nil
+}
+
+// loadKey 读取公钥文件，PEM头中可以用Expires指定过期时间
+func loadKey(filename string) (trustedKey, error) {
+	bs, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return trustedKey{}, err
+	}
+	block, _ := pem.Decode(bs)
+	if block == nil {
+		return trustedKey{}, errors.New("this is not the correct key")
+	}
+
+	var key trustedKey
+	if v, ok := block.Headers[keyExpires]; ok {
+		if key.expires, err = time.Parse(time.RFC3339, v); err != nil {
+			return trustedKey{}, fmt.Errorf("invalid %s header: %v", keyExpires, err)
+		}
+	}
+	key.key, err = utils.ParsePublicKey(bs)
+	return key, err
+}
+
+// parseSignaturePolicy 解析签名策略，每条规则的格式为"数量:公钥ID,公钥ID"，公钥ID为*时为任意公钥
+func parseSignaturePolicy(rules []string, keys map[string]trustedKey) ([]signatureRule, error) {
+	var policy []signatureRule
+	for _, v := range rules {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		parts := strings.SplitN(v, policySeparate, 2)
+		threshold, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+		if err != nil || threshold < 1 || len(parts) != 2 {
+			return nil, fmt.Errorf("invalid signature policy %q", v)
+		}
+
+		rule := signatureRule{threshold: threshold}
+		for _, id := range strings.Split(parts[1], ",") {
+			id = strings.TrimSpace(id)
+			if id == anyKey {
+				rule.keys = nil
+				break
+			}
+			if _, ok := keys[id]; !ok {
+				return nil, fmt.Errorf("signature policy %q: key %s is not trusted", v, id)
+			}
+			rule.keys = append(rule.keys, id)
+		}
+		if rule.keys != nil && threshold > len(rule.keys) {
+			return nil, fmt.Errorf("signature policy %q can never be satisfied", v)
+		}
+		policy = append(policy, rule)
+	}
+	return policy, nil
+}
+
+// verifySignatures 验证描述文件的签名
+// 签名文件每行一个签名，格式为"公钥ID 签名"，没有公钥ID时使用keyfile中的公钥验证；
+// 过期公钥的签名不计入，每个公钥只计一次，有效签名需满足签名策略中的每一条规则，
+// 未配置签名策略时需要至少一个有效签名
+func (core *Core) verifySignatures(data, sig []byte) error {
+	valid := make(map[string]struct{})
+	for _, line := range strings.Split(string(sig), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		id, sign := defaultKeyId, fields[0]
+		if len(fields) == 2 {
+			id, sign = fields[0], fields[1]
+		} else if len(fields) != 1 {
+			return errors.New("invalid sign line")
+		}
+
+		key, ok := core.keys[id]
+		if !ok {
+			log.Printf("ignore sign of unknown key %s", id)
+			continue
+		}
+		if !key.expires.IsZero() && time.Now().After(key.expires) {
+			log.Printf("ignore sign of key %s, which expired at %s", id, key.expires.Format(time.RFC3339))
+			continue
+		}
+		if utils.VerifySignWithSha256(data, sign, key.key) {
+			valid[id] = struct{}{}
+		}
+	}
+
+	if len(valid) == 0 {
+		return errors.New("sign is not right")
+	}
+	for _, rule := range core.signaturePolicy {
+		count := len(valid)
+		if rule.keys != nil {
+			count = 0
+			for _, id := range rule.keys {
+				if _, ok := valid[id]; ok {
+					count++
+				}
+			}
+		}
+		if count < rule.threshold {
+			return fmt.Errorf("signature policy is not satisfied: need %d valid signs from %s, got %d", rule.threshold, rule.describe(), count)
+		}
+	}
+	return nil
+}
+
+// describe 返回规则中的公钥ID，用于错误信息
+func (rule signatureRule) describe() string {
+	if rule.keys == nil {
+		return "any key"
+	}
+	return strings.Join(rule.keys, ",")
+}
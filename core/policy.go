@@ -0,0 +1,92 @@
+package core
+
+import (
+	"fmt"
+	"github.com/ruixiaoedu/ota/models"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pathPolicy 升级包可以写入的路径，前缀相对安装根目录
+type pathPolicy struct {
+	allow   []string // 允许的前缀，为空时不限制
+	deny    []string // 禁止的前缀，优先于allow
+	devices bool     // 是否允许写入设备文件
+	setuid  bool     // 是否允许设置setuid和setgid位
+	xattrs  bool     // 是否允许设置security.*扩展属性，如security.capability
+}
+
+// securityXattrPrefix 内核用于安全机制的扩展属性前缀，文件能力等同于setuid
+const securityXattrPrefix = "security."
+
+// check 在写入任何内容之前检查描述文件中的路径，描述文件中的路径已在安装根目录下解析
+// 状态目录总是禁止写入
+func (p *pathPolicy) check(description *models.Description, root, stateDir string) error {
+	deny := []string{filepath.Clean(stateDir)}
+	for _, v := range p.deny {
+		deny = append(deny, filepath.Join(root, v))
+	}
+	var allow []string
+	for _, v := range p.allow {
+		allow = append(allow, filepath.Join(root, v))
+	}
+
+	// 递归修改的目录中不能包含禁止的路径
+	checkPath := func(name string, tree bool) error {
+		if underAny(name, deny) {
+			return fmt.Errorf("path %s is denied by policy", name)
+		}
+		if tree {
+			for _, v := range deny {
+				if strings.HasPrefix(v, name+"/") {
+					return fmt.Errorf("path %s contains %s which is denied by policy", name, v)
+				}
+			}
+		}
+		if len(allow) > 0 && !underAny(name, allow) {
+			return fmt.Errorf("path %s is not allowed by policy", name)
+		}
+		if !p.devices {
+			if fi, err := os.Lstat(name); err == nil && fi.Mode()&(os.ModeDevice|os.ModeCharDevice) != 0 {
+				return fmt.Errorf("path %s is a device, which is not allowed by policy", name)
+			}
+		}
+		return nil
+	}
+
+	for _, v := range description.Files {
+		if err := checkPath(v.Path, v.Mirror); err != nil {
+			return err
+		}
+		if fileType(v) == models.FileHardlink {
+			if err := checkPath(v.Target, false); err != nil {
+				return err
+			}
+		}
+		if !p.setuid && v.Mode != "" {
+			if mode, err := parseMode(v.Mode); err == nil && mode&(os.ModeSetuid|os.ModeSetgid) != 0 {
+				return fmt.Errorf("path %s sets setuid or setgid bits, which is not allowed by policy", v.Path)
+			}
+		}
+		// 未声明权限时沿用已有文件的权限，替换setuid文件的内容等同于设置setuid位
+		if !p.setuid && v.Mode == "" && fileType(v) == models.FileRegular {
+			if fi, err := os.Stat(v.Path); err == nil && fi.Mode().IsRegular() && fi.Mode()&(os.ModeSetuid|os.ModeSetgid) != 0 {
+				return fmt.Errorf("path %s has setuid or setgid bits, replacing it is not allowed by policy", v.Path)
+			}
+		}
+		if !p.xattrs {
+			for name := range v.Xattrs {
+				if strings.HasPrefix(name, securityXattrPrefix) {
+					return fmt.Errorf("path %s sets xattr %s, which is not allowed by policy", v.Path, name)
+				}
+			}
+		}
+	}
+	for _, v := range description.Remove {
+		if err := checkPath(filepath.Clean(v), true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package core
+
+import (
+	"fmt"
+	"github.com/ruixiaoedu/ota/models"
+	"github.com/ruixiaoedu/ota/utils"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Remove 卸载安装根目录下的包，root为空时使用默认安装根目录
+func (core *Core) Remove(name, root string) (*models.History, error) {
+	core.mu.Lock()
+	defer core.mu.Unlock()
+
+	root, err := core.installRoot(&models.UpdateOptions{Root: root})
+	if err != nil {
+		return nil, err
+	}
+
+	pkg, err := core.installedPackage(name, root)
+	if err != nil {
+		return nil, err
+	}
+	if pkg == nil {
+		return nil, fmt.Errorf("package %s is not installed in %s", name, root)
+	}
+
+	j := newJournal(core.journalPath(), "", root)
+	j.Operation = models.OperationRemove
+	j.Name, j.Version = pkg.Name, pkg.Version
+	if err = j.saveRecord(pkg); err != nil {
+		return nil, err
+	}
+	if err = j.step(stepVerified); err != nil {
+		return nil, err
+	}
+
+	output, err := core.openScriptLog(j.Id)
+	if err != nil {
+		return nil, err
+	}
+	defer output.Close()
+
+	err = core.remove(j, output)
+	h, herr := core.recordHistory(j, false, err)
+	if herr != nil {
+		log.Println("record history fail", herr)
+	}
+	if jerr := j.remove(); jerr != nil {
+		log.Println("remove journal fail", jerr)
+	}
+
+	return h, err
+}
+
+// newRemoveEnv 生成卸载脚本的运行环境，脚本在保存目录中运行
+func (core *Core) newRemoveEnv(pkg *models.Package, output *scriptLog) *scriptEnv {
+	return &scriptEnv{
+		Name:            pkg.Name,
+		Version:         pkg.Version,
+		PreviousVersion: pkg.Version,
+		PackageDir:      pkg.ScriptDir,
+		Root:            pkg.Root,
+		output:          output,
+	}
+}
+
+// remove 执行卸载脚本，并在事务中删除包拥有的路径
+func (core *Core) remove(j *journal, output *scriptLog) error {
+	env := core.newRemoveEnv(j.Record, output)
+	scripts := scriptsByType(j.Record.Scripts)
+	if _, err := core.runScripts(env, scripts[models.ScriptPreremove]); err != nil {
+		return err
+	}
+
+	if err := j.step(stepPreinstalled); err != nil {
+		return err
+	}
+
+	tx, err := newTransaction(core.backupDir(), j)
+	if err != nil {
+		return err
+	}
+	core.onCommit(tx)
+
+	if err = core.uninstall(tx, j.Record); err != nil {
+		return tx.abort(err)
+	}
+
+	if err = j.step(stepInstalled); err != nil {
+		return tx.abort(err)
+	}
+
+	return core.finish(tx, env, scripts, models.ScriptPostremove)
+}
+
+// uninstall 删除包拥有的路径
+// 本地修改过的配置文件和另存的配置文件保留，目录（包括安装时新建的上级目录）只在为空且不属于其他包时删除
+func (core *Core) uninstall(tx *transaction, pkg *models.Package) error {
+	pkgs, err := core.packages()
+	if err != nil {
+		return err
+	}
+	shared := make(map[string]struct{})
+	for _, p := range pkgs {
+		if p.Root != pkg.Root || p.Name == pkg.Name {
+			continue
+		}
+		for _, f := range p.Files {
+			shared[f.Path] = struct{}{}
+		}
+	}
+
+	var dirs []string
+	for _, f := range pkg.Files {
+		if f.Type == models.FileDir {
+			dirs = append(dirs, f.Path)
+			continue
+		}
+
+		fi, err := os.Lstat(f.Path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			tx.warn(fmt.Sprintf("%s has been replaced by a directory, kept", f.Path))
+			continue
+		}
+		if f.Conffile && f.Sha256 != "" {
+			modified, err := changed(f.Path, f.Sha256)
+			if err != nil {
+				return err
+			}
+			if modified {
+				tx.warn(fmt.Sprintf("%s has local changes, kept", f.Path))
+				continue
+			}
+		}
+
+		if err = tx.backup(f.Path); err != nil {
+			return err
+		}
+		if err = os.Remove(f.Path); err != nil {
+			return fmt.Errorf("remove %s fail: %v", f.Path, err)
+		}
+	}
+
+	// 另存的新版本配置文件与包中的内容相同时删除
+	hashes := make(map[string]string)
+	for _, f := range pkg.Files {
+		if f.Conffile {
+			hashes[f.Path+conffileSuffix] = f.Sha256
+		}
+	}
+	for _, name := range pkg.NewConffiles {
+		if _, err := os.Lstat(name); os.IsNotExist(err) {
+			continue
+		}
+		modified := true
+		if sha256 := hashes[name]; sha256 != "" {
+			if modified, err = changed(name, sha256); err != nil {
+				return err
+			}
+		}
+		if modified {
+			tx.warn(fmt.Sprintf("%s has local changes, kept", name))
+			continue
+		}
+		if err = tx.backup(name); err != nil {
+			return err
+		}
+		if err = os.Remove(name); err != nil {
+			return fmt.Errorf("remove %s fail: %v", name, err)
+		}
+	}
+
+	// 由深到浅删除空目录
+	dirs = append(dirs, pkg.Dirs...)
+	sort.SliceStable(dirs, func(i, j int) bool {
+		return strings.Count(dirs[i], "/") > strings.Count(dirs[j], "/")
+	})
+	for _, dir := range dirs {
+		if _, ok := shared[dir]; ok {
+			continue
+		}
+		entries, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) || (err == nil && len(entries) > 0) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err = tx.backup(dir); err != nil {
+			return err
+		}
+		if err = os.Remove(dir); err != nil {
+			return fmt.Errorf("remove %s fail: %v", dir, err)
+		}
+	}
+
+	return tx.journal.save()
+}
+
+// changed 文件内容是否与安装时不同，非普通文件视为不同
+func changed(name, sha256 string) (bool, error) {
+	fi, err := os.Lstat(name)
+	if err != nil {
+		return false, err
+	}
+	if !fi.Mode().IsRegular() {
+		return true, nil
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	hex, err := utils.Sha256FromReader(f)
+	if err != nil {
+		return false, err
+	}
+	return hex != sha256, nil
+}
@@ -0,0 +1,462 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ruixiaoedu/ota/models"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// scriptPath 脚本使用的PATH
+const scriptPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+const (
+	scriptOutputLines = 10              // 脚本失败时保留的输出行数
+	scriptLineSize    = 4096            // 脚本输出单行的最大长度，超过时拆分
+	scriptKillGrace   = 5 * time.Second // 超时发送SIGTERM后，等待多久发送SIGKILL
+	scriptDrainGrace  = time.Second     // 脚本退出后，等待后台进程关闭输出管道的时间
+)
+
+// ErrScriptTimeout 脚本执行超时
+var ErrScriptTimeout = errors.New("script timeout")
+
+// ScriptError 脚本执行失败
+type ScriptError struct {
+	Name     string   // 脚本文件名
+	Type     string   // 脚本类型
+	ExitCode int      // 退出码，-1表示脚本未能正常退出
+	Output   []string // 最后几行输出
+	Err      error    // 失败原因
+}
+
+func (e *ScriptError) Error() string {
+	msg := fmt.Sprintf("%s script %s fail (exit code %d): %v", e.Type, e.Name, e.ExitCode, e.Err)
+	if len(e.Output) > 0 {
+		msg += "\n" + strings.Join(e.Output, "\n")
+	}
+	return msg
+}
+
+func (e *ScriptError) Unwrap() error {
+	return e.Err
+}
+
+// onFailure 脚本失败时的处理策略
+// 未设置时，修改文件之前的脚本为终止，安装完成后的脚本为回滚，回滚和卸载后的脚本为忽略
+func onFailure(script models.Script) string {
+	if script.OnFailure != "" {
+		return script.OnFailure
+	}
+	switch script.Type {
+	case models.ScriptPrecheck, models.ScriptPreinstall, models.ScriptPreremove:
+		return models.OnFailureAbort
+	case models.ScriptPostinstall, models.ScriptVerify:
+		return models.OnFailureRollback
+	default:
+		return models.OnFailureIgnore
+	}
+}
+
+// scriptsByType 按类型对脚本分组
+func scriptsByType(scripts []models.Script) map[string][]models.Script {
+	m := make(map[string][]models.Script)
+	for _, v := range scripts {
+		m[v.Type] = append(m[v.Type], v)
+	}
+	return m
+}
+
+// scriptEnv 脚本的运行环境
+//
+// 脚本以OTA_PACKAGE_DIR为工作目录运行，不继承守护进程的环境变量，只能获得：
+//
+//	PATH                 固定为scriptPath
+//	OTA_NAME             包名称
+//	OTA_VERSION          待安装的版本
+//	OTA_PREVIOUS_VERSION 当前已安装的版本，首次安装时为空
+//	OTA_STAGE            脚本所处的阶段，即脚本的type，同一脚本可用于多个阶段
+//	OTA_PACKAGE_DIR      解压后的升级包目录，卸载时为卸载脚本的保存目录
+//	OTA_REBOOT           升级完成后是否需要重启，为1或0
+//	OTA_ROOT             安装根目录
+//
+// 使用独立的临时目录时，另外设置TMPDIR
+type scriptEnv struct {
+	Name            string
+	Version         string
+	PreviousVersion string
+	PackageDir      string
+	Reboot          bool
+	Root            string
+
+	output *scriptLog // 脚本输出日志
+}
+
+// newScriptEnv 根据描述文件生成脚本的运行环境
+func (core *Core) newScriptEnv(dir, root string, description *models.Description, output *scriptLog) *scriptEnv {
+	if root == "" {
+		root = "/"
+	}
+	return &scriptEnv{
+		Name:            description.Name,
+		Version:         description.Version,
+		PreviousVersion: core.previousVersion(description.Name, root),
+		PackageDir:      dir,
+		Reboot:          description.Reboot,
+		Root:            root,
+		output:          output,
+	}
+}
+
+// environ 生成指定阶段的环境变量
+func (e *scriptEnv) environ(stage string) []string {
+	reboot := "0"
+	if e.Reboot {
+		reboot = "1"
+	}
+	return []string{
+		"PATH=" + scriptPath,
+		"OTA_NAME=" + e.Name,
+		"OTA_VERSION=" + e.Version,
+		"OTA_PREVIOUS_VERSION=" + e.PreviousVersion,
+		"OTA_STAGE=" + stage,
+		"OTA_PACKAGE_DIR=" + e.PackageDir,
+		"OTA_REBOOT=" + reboot,
+		"OTA_ROOT=" + e.Root,
+	}
+}
+
+// scriptName 脚本名称，内联脚本没有文件名
+func scriptName(script models.Script) string {
+	if script.Filename != "" {
+		return script.Filename
+	}
+	return "inline"
+}
+
+// runScript 执行升级包中的脚本
+func (core *Core) runScript(env *scriptEnv, script models.Script) error {
+	timeout := core.scriptTimeout
+	if script.Timeout > 0 {
+		timeout = time.Duration(script.Timeout) * time.Second
+	}
+
+	cmd, cleanup, err := scriptCommand(env.PackageDir, script)
+	if err != nil {
+		return &ScriptError{Name: scriptName(script), Type: script.Type, ExitCode: -1, Err: err}
+	}
+	defer cleanup()
+
+	cmd.Dir = env.PackageDir
+	cmd.Env = env.environ(script.Type)
+
+	cmd, release, err := core.confine(cmd, script)
+	if err != nil {
+		return &ScriptError{Name: scriptName(script), Type: script.Type, ExitCode: -1, Err: err}
+	}
+	defer release()
+
+	label := script.Type + " " + scriptName(script)
+	env.output.write(label, "start")
+	exitCode, output, err := execute(cmd, timeout, func(stream, line string) {
+		env.output.write(label+" "+stream, line)
+	})
+	env.output.write(label, fmt.Sprintf("exit code %d", exitCode))
+	if err != nil {
+		return &ScriptError{
+			Name:     scriptName(script),
+			Type:     script.Type,
+			ExitCode: exitCode,
+			Output:   output,
+			Err:      err,
+		}
+	}
+	return nil
+}
+
+// scriptCommand 生成执行脚本的命令，不经过shell
+// 指定了解释器时由解释器执行脚本，否则以#!开头的脚本直接执行，其余脚本由/bin/sh执行
+func scriptCommand(dir string, script models.Script) (*exec.Cmd, func(), error) {
+	cleanup := func() {}
+
+	filename := path.Join(dir, script.Filename)
+	if script.Content != "" {
+		// 内联脚本写入升级包目录中的临时文件
+		f, err := ioutil.TempFile(dir, ".script-")
+		if err != nil {
+			return nil, nil, err
+		}
+		if err = f.Chmod(0644); err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return nil, nil, err
+		}
+		if _, err = f.WriteString(script.Content); err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return nil, nil, err
+		}
+		f.Close()
+		filename = f.Name()
+		cleanup = func() { os.Remove(filename) }
+	}
+
+	interpreter := script.Interpreter
+	if interpreter == "" {
+		shebang, err := hasShebang(filename)
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		if !shebang {
+			interpreter = "/bin/sh"
+		}
+	}
+
+	if interpreter == "" {
+		// 直接执行的脚本需要可执行权限
+		fi, err := os.Stat(filename)
+		if err == nil {
+			err = os.Chmod(filename, fi.Mode()|os.FileMode(0111))
+		}
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		return exec.Command(filename, script.Args...), cleanup, nil
+	}
+
+	name, err := lookPath(interpreter)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return exec.Command(name, append([]string{filename}, script.Args...)...), cleanup, nil
+}
+
+// hasShebang 文件是否以#!开头
+func hasShebang(filename string) (bool, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 2)
+	if _, err = io.ReadFull(f, buf); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		return false, err
+	}
+	return string(buf) == "#!", nil
+}
+
+// lookPath 在脚本的PATH中查找解释器
+func lookPath(name string) (string, error) {
+	if strings.Contains(name, "/") {
+		return name, nil
+	}
+	for _, dir := range filepath.SplitList(scriptPath) {
+		filename := filepath.Join(dir, name)
+		if fi, err := os.Stat(filename); err == nil && !fi.IsDir() && fi.Mode()&0111 != 0 {
+			return filename, nil
+		}
+	}
+	return "", fmt.Errorf("interpreter %s not found in %s", name, scriptPath)
+}
+
+// runScripts 依次执行脚本，跳过策略为忽略的失败，返回第一个需要处理的失败及其策略
+func (core *Core) runScripts(env *scriptEnv, scripts []models.Script) (string, error) {
+	for _, v := range scripts {
+		if err := core.runScript(env, v); err != nil {
+			policy := onFailure(v)
+			if policy == models.OnFailureIgnore {
+				log.Printf("ignore script failure: %v", err)
+				continue
+			}
+			return policy, err
+		}
+	}
+	return "", nil
+}
+
+// outputTail 保存最后几行输出
+type outputTail struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+// snapshot 返回当前保存的输出
+func (t *outputTail) snapshot() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.lines...)
+}
+
+func (t *outputTail) add(line string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lines = append(t.lines, line)
+	if len(t.lines) > scriptOutputLines {
+		t.lines = t.lines[len(t.lines)-scriptOutputLines:]
+	}
+}
+
+// 按行读取输出，超过scriptLineSize的行拆分为多行
+func asyncLog(reader io.ReadCloser, tail *outputTail, emit func(line string)) error {
+	cache := ""
+	buf := make([]byte, 1024, 1024)
+	for {
+		num, err := reader.Read(buf)
+		if err != nil {
+			if cache != "" {
+				emit(cache)
+				tail.add(cache)
+			}
+			if err == io.EOF || strings.Contains(err.Error(), "closed") {
+				err = nil
+			}
+			return err
+		}
+		if num > 0 {
+			oByte := buf[:num]
+			oSlice := strings.Split(string(oByte), "\n")
+			oSlice[0] = cache + oSlice[0]
+			for _, line := range oSlice[:len(oSlice)-1] {
+				for len(line) > scriptLineSize {
+					emit(line[:scriptLineSize])
+					tail.add(line[:scriptLineSize])
+					line = line[scriptLineSize:]
+				}
+				emit(line)
+				tail.add(line)
+			}
+			cache = oSlice[len(oSlice)-1]
+			for len(cache) > scriptLineSize {
+				emit(cache[:scriptLineSize])
+				tail.add(cache[:scriptLineSize])
+				cache = cache[scriptLineSize:]
+			}
+		}
+	}
+}
+
+// 执行命令，返回退出码和最后几行输出，每行输出连同所属的流名称交给output
+// 命令在独立的进程组中运行，超时后先向整个进程组发送SIGTERM，仍未退出则发送SIGKILL
+// 超时只计算脚本进程本身，脚本退出后其后台进程可能仍持有输出管道，最多再等待scriptDrainGrace
+func execute(cmd *exec.Cmd, timeout time.Duration, output func(stream, line string)) (int, []string, error) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+
+	// 使用os.Pipe，cmd.Wait在脚本退出后即返回，不等待输出管道关闭
+	stdout, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		return -1, nil, err
+	}
+	stderr, stderrWriter, err := os.Pipe()
+	if err != nil {
+		stdout.Close()
+		stdoutWriter.Close()
+		return -1, nil, err
+	}
+	cmd.Stdout, cmd.Stderr = stdoutWriter, stderrWriter
+
+	err = cmd.Start()
+	stdoutWriter.Close()
+	stderrWriter.Close()
+	if err != nil {
+		stdout.Close()
+		stderr.Close()
+		log.Printf("Error starting command: %s......", err.Error())
+		return -1, nil, err
+	}
+
+	// 停止等待后，读取协程继续读到EOF并丢弃输出，避免后台进程因管道关闭收到SIGPIPE
+	var tail outputTail
+	var detached int32
+	emit := func(stream string) func(line string) {
+		return func(line string) {
+			if atomic.LoadInt32(&detached) == 0 {
+				output(stream, line)
+			}
+		}
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer stdout.Close()
+		asyncLog(stdout, &tail, emit("stdout"))
+	}()
+	go func() {
+		defer wg.Done()
+		defer stderr.Close()
+		asyncLog(stderr, &tail, emit("stderr"))
+	}()
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+	drain := func() []string {
+		select {
+		case <-drained:
+		case <-time.After(scriptDrainGrace):
+			log.Printf("command %s exited, but its output is still open, stop reading", cmd.Path)
+		}
+		atomic.StoreInt32(&detached, 1)
+		return tail.snapshot()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case err = <-done:
+	case <-expired:
+		pgid := cmd.Process.Pid
+		log.Printf("command %s timeout after %v, terminating......", cmd.Path, timeout)
+		_ = syscall.Kill(-pgid, syscall.SIGTERM)
+		select {
+		case <-done:
+		case <-time.After(scriptKillGrace):
+			_ = syscall.Kill(-pgid, syscall.SIGKILL)
+			<-done
+		}
+		return -1, drain(), ErrScriptTimeout
+	}
+
+	lines := drain()
+	if err != nil {
+		log.Printf("Error waiting for command execution: %s......", err.Error())
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode(), lines, err
+		}
+		return -1, lines, err
+	}
+
+	return 0, nil, nil
+}
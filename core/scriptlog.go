@@ -0,0 +1,126 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	keepScriptLogs   = 20  // 保留最近多少次升级的脚本输出
+	truncatedReserve = 128 // 为截断提示预留的大小，保证日志不超过上限
+)
+
+// scriptLog 单次升级的脚本输出日志，每行带有时间和来源，超过大小上限后丢弃后续输出
+type scriptLog struct {
+	mu        sync.Mutex
+	file      *os.File
+	size      int64 // 已写入的大小
+	limit     int64 // 大小上限，为0时不限制
+	truncated bool  // 是否已丢弃输出
+}
+
+// openScriptLog 打开脚本输出日志，已存在时追加
+func openScriptLog(filename string, limit int64) (*scriptLog, error) {
+	if err := os.MkdirAll(path.Dir(filename), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &scriptLog{file: f, size: fi.Size(), limit: limit}, nil
+}
+
+// write 写入一行输出，label为输出的来源
+func (l *scriptLog) write(label, line string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.truncated {
+		return
+	}
+
+	record := fmt.Sprintf("%s [%s] %s\n", time.Now().Format("2006-01-02T15:04:05.000Z07:00"), label, line)
+	if l.limit > 0 && l.size+int64(len(record))+truncatedReserve > l.limit {
+		l.truncated = true
+		record = fmt.Sprintf("%s [ota] output truncated at %d bytes\n", time.Now().Format("2006-01-02T15:04:05.000Z07:00"), l.limit)
+	}
+
+	n, _ := l.file.WriteString(record)
+	l.size += int64(n)
+}
+
+// Close 关闭日志
+func (l *scriptLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// logDir 脚本输出日志目录
+func (core *Core) logDir() string {
+	return path.Join(core.stateDir, "logs")
+}
+
+// openScriptLog 打开指定升级的脚本输出日志，并清理过旧的日志
+func (core *Core) openScriptLog(id string) (*scriptLog, error) {
+	l, err := openScriptLog(path.Join(core.logDir(), id+".log"), core.scriptLogSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// 升级ID按时间生成，按名称排序即按时间排序
+	if fis, err := ioutil.ReadDir(core.logDir()); err == nil && len(fis) > keepScriptLogs {
+		var names []string
+		for _, fi := range fis {
+			names = append(names, fi.Name())
+		}
+		sort.Strings(names)
+		for _, name := range names[:len(names)-keepScriptLogs] {
+			os.Remove(path.Join(core.logDir(), name))
+		}
+	}
+
+	return l, nil
+}
+
+// Log 读取升级的脚本输出，id为空时读取最近一次升级
+func (core *Core) Log(id string) (string, error) {
+	if id == "" {
+		id = core.lastUpdateId()
+		if id == "" {
+			return "", errors.New("no update found")
+		}
+	}
+
+	// 升级ID只包含数字、'-'和'.'，拒绝其他字符，防止读取日志目录外的文件
+	if strings.Trim(id, "0123456789-.") != "" || strings.Contains(id, "..") {
+		return "", fmt.Errorf("invalid update id %s", id)
+	}
+
+	bs, err := ioutil.ReadFile(path.Join(core.logDir(), id+".log"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("no log for update %s", id)
+		}
+		return "", err
+	}
+	return string(bs), nil
+}
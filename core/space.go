@@ -0,0 +1,112 @@
+package core
+
+import (
+	"fmt"
+	"github.com/ruixiaoedu/ota/models"
+	"golang.org/x/sys/unix"
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+)
+
+// archiveLimits 解压升级包的限制，为0时不限制
+type archiveLimits struct {
+	total   int64 // 解压后的总大小
+	entry   int64 // 单个条目的大小
+	entries int   // 条目数量
+}
+
+// existingPath 返回name或其最近的已存在的上级路径
+func existingPath(name string) string {
+	for {
+		if _, err := os.Lstat(name); err == nil {
+			return name
+		}
+		parent := filepath.Dir(name)
+		if parent == name {
+			return name
+		}
+		name = parent
+	}
+}
+
+// deviceOf 返回路径所在的设备号
+func deviceOf(name string) (uint64, error) {
+	fi, err := os.Lstat(name)
+	if err != nil {
+		return 0, err
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Dev), nil
+	}
+	return 0, nil
+}
+
+// freeSpace 返回路径所在文件系统中非特权用户可用的空间
+func freeSpace(name string) (int64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(name, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+// checkSpace 复制文件前检查安装位置和状态目录所在文件系统的剩余空间
+// 新文件写入目标所在的文件系统，被替换的文件跨文件系统时复制到状态目录中备份
+func (core *Core) checkSpace(dir string, description *models.Description) error {
+	type filesystem struct {
+		path string // 文件系统中已存在的路径
+		need int64  // 所需空间
+	}
+	filesystems := make(map[uint64]*filesystem)
+	require := func(name string, size int64) (uint64, error) {
+		name = existingPath(name)
+		dev, err := deviceOf(name)
+		if err != nil {
+			return 0, err
+		}
+		if fs, ok := filesystems[dev]; ok {
+			fs.need += size
+		} else {
+			filesystems[dev] = &filesystem{path: name, need: size}
+		}
+		return dev, nil
+	}
+
+	stateDev, err := require(core.stateDir, 0)
+	if err != nil {
+		return err
+	}
+	for _, v := range description.Files {
+		if fileType(v) != models.FileRegular {
+			continue
+		}
+		fi, err := os.Stat(path.Join(dir, v.Filename))
+		if err != nil {
+			return err
+		}
+		dev, err := require(filepath.Dir(v.Path), fi.Size())
+		if err != nil {
+			return err
+		}
+
+		if old, err := os.Lstat(v.Path); err == nil && old.Mode().IsRegular() && dev != stateDev {
+			filesystems[stateDev].need += old.Size()
+		}
+	}
+
+	for _, fs := range filesystems {
+		if fs.need == 0 {
+			continue
+		}
+		free, err := freeSpace(fs.path)
+		if err != nil {
+			return err
+		}
+		if fs.need > free {
+			return fmt.Errorf("not enough space on the filesystem of %s: need %d bytes, %d available", fs.path, fs.need, free)
+		}
+	}
+	return nil
+}
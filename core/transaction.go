@@ -0,0 +1,272 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ruixiaoedu/ota/utils"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// backupEntry 事务中被修改的路径
+type backupEntry struct {
+	Path   string            `json:"path"`             // 被修改的路径
+	Backup string            `json:"backup"`           // 备份文件路径，为空表示修改前路径不存在
+	Dir    bool              `json:"dir"`              // 是否为安装时新建的目录
+	Link   string            `json:"link,omitempty"`   // 原符号链接指向的目标
+	Attrs  bool              `json:"attrs,omitempty"`  // 是否为已有目录，回滚时恢复属性，被删除时重新创建
+	Mode   os.FileMode       `json:"mode"`             // 原文件权限
+	Uid    int               `json:"uid"`              // 原文件所有者
+	Gid    int               `json:"gid"`              // 原文件所属组
+	Xattrs map[string][]byte `json:"xattrs,omitempty"` // 原文件的扩展属性
+}
+
+// transaction 安装事务，修改前备份所有涉及的路径，失败时据此回滚
+type transaction struct {
+	dir     string              // 备份目录
+	entries []backupEntry       // 按修改顺序记录的路径
+	touched map[string]struct{} // 已备份的路径
+	journal *journal            // 安装日志，备份记录先于修改落盘
+
+	afterRollback func() error // 回滚完成后执行
+	afterCommit   func() error // 提交完成后执行
+}
+
+// newTransaction 创建安装事务，清空上一次遗留的备份
+func newTransaction(dir string, j *journal) (*transaction, error) {
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := j.reset(); err != nil {
+		return nil, err
+	}
+	if err := j.step(stepInstalling); err != nil {
+		return nil, err
+	}
+	return &transaction{
+		dir:     dir,
+		touched: make(map[string]struct{}),
+		journal: j,
+	}, nil
+}
+
+// backup 在修改name前备份，同一路径只备份一次
+func (tx *transaction) backup(name string) error {
+	if _, ok := tx.touched[name]; ok {
+		return nil
+	}
+	tx.touched[name] = struct{}{}
+
+	// 记录需要新建的上级目录，回滚时删除
+	var dirs []string
+	for dir := filepath.Dir(name); !utils.FileExist(dir); dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if _, ok := tx.touched[dirs[i]]; ok {
+			continue
+		}
+		tx.touched[dirs[i]] = struct{}{}
+		if err := tx.record(backupEntry{Path: dirs[i], Dir: true}); err != nil {
+			return err
+		}
+	}
+
+	fi, err := os.Lstat(name)
+	if os.IsNotExist(err) {
+		return tx.record(backupEntry{Path: name})
+	} else if err != nil {
+		return err
+	}
+
+	entry := backupEntry{
+		Path: name,
+		Mode: fi.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky),
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		entry.Uid, entry.Gid = int(st.Uid), int(st.Gid)
+	}
+	if entry.Xattrs, err = listXattrs(name); err != nil {
+		return fmt.Errorf("backup %s fail: %v", name, err)
+	}
+
+	switch {
+	case fi.Mode().IsRegular():
+		// 安装采用重命名替换，原文件的inode不会被修改，优先使用硬链接备份
+		entry.Backup = filepath.Join(tx.dir, strconv.Itoa(len(tx.entries)))
+		if err = os.Link(name, entry.Backup); err != nil {
+			if err = utils.CopyFile(name, entry.Backup); err != nil {
+				return fmt.Errorf("backup %s fail: %v", name, err)
+			}
+		}
+		// 备份落盘后才能记录到日志，否则断电后日志可能指向不存在的备份
+		if err = utils.SyncDir(tx.dir); err != nil {
+			return fmt.Errorf("backup %s fail: %v", name, err)
+		}
+	case fi.Mode()&os.ModeSymlink != 0:
+		if entry.Link, err = os.Readlink(name); err != nil {
+			return fmt.Errorf("backup %s fail: %v", name, err)
+		}
+	case fi.IsDir():
+		entry.Attrs = true
+	default:
+		return fmt.Errorf("%s is not a regular file, symlink or directory", name)
+	}
+
+	return tx.record(entry)
+}
+
+// warn 在安装日志中记录警告
+func (tx *transaction) warn(warning string) {
+	log.Println(warning)
+	tx.journal.Warnings = append(tx.journal.Warnings, warning)
+}
+
+// record 将备份记录追加到安装日志
+func (tx *transaction) record(entry backupEntry) error {
+	if err := tx.journal.backup(entry); err != nil {
+		return err
+	}
+	tx.entries = append(tx.entries, entry)
+	return nil
+}
+
+// rollback 按相反顺序恢复所有被修改的路径
+func (tx *transaction) rollback() error {
+	var errs []string
+	for i := len(tx.entries) - 1; i >= 0; i-- {
+		if err := tx.entries[i].restore(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d path(s) not restored: %v", len(errs), errs)
+	}
+	return os.RemoveAll(tx.dir)
+}
+
+// commit 提交事务，删除备份
+func (tx *transaction) commit() error {
+	if tx.afterCommit != nil {
+		if err := tx.afterCommit(); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(tx.dir)
+}
+
+// abort 回滚事务，并返回包含回滚结果的错误
+func (tx *transaction) abort(err error) error {
+	rollbackErr := tx.rollback()
+	if rollbackErr == nil && tx.afterRollback != nil {
+		rollbackErr = tx.afterRollback()
+	}
+	return &InstallError{Err: err, RollbackErr: rollbackErr}
+}
+
+// restore 恢复单个路径
+func (e backupEntry) restore() error {
+	switch {
+	case e.Dir || (e.Backup == "" && e.Link == "" && !e.Attrs):
+		// 删除新建的路径，目录只在为空时删除，保留安装之外新增的内容
+		if err := os.Remove(e.Path); err != nil && !os.IsNotExist(err) && !isNotEmpty(err) {
+			return err
+		}
+		return nil
+	case e.Attrs:
+		// 升级时删除的目录需要重新创建，原位置可能已被其他类型的条目替换
+		if err := removeIf(e.Path, func(fi os.FileInfo) bool { return !fi.IsDir() }); err != nil {
+			return err
+		}
+		if err := os.Mkdir(e.Path, e.Mode.Perm()); err != nil && !os.IsExist(err) {
+			return err
+		}
+		if err := os.Lchown(e.Path, e.Uid, e.Gid); err != nil {
+			return err
+		}
+		if err := os.Chmod(e.Path, e.Mode); err != nil {
+			return err
+		}
+		return resetXattrs(e.Path, e.Xattrs)
+	}
+
+	// 原位置可能已被目录替换，此时目录中的内容已先行回滚
+	if err := removeIf(e.Path, func(fi os.FileInfo) bool { return fi.IsDir() }); err != nil {
+		return err
+	}
+
+	if e.Link != "" {
+		if err := utils.SymlinkAtomic(e.Link, e.Path); err != nil {
+			return err
+		}
+		if err := os.Lchown(e.Path, e.Uid, e.Gid); err != nil {
+			return err
+		}
+		return setXattrs(e.Path, e.Xattrs)
+	}
+
+	source, err := os.Open(e.Backup)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	// 先修改所有者再修改权限，避免chown清除setuid位
+	err = utils.WriteFileAtomic(e.Path, source, func(f *os.File) error {
+		if err := f.Chown(e.Uid, e.Gid); err != nil {
+			return err
+		}
+		return f.Chmod(e.Mode)
+	})
+	if err != nil {
+		return err
+	}
+	return setXattrs(e.Path, e.Xattrs)
+}
+
+// removeIf 路径存在且满足条件时删除
+func removeIf(name string, cond func(fi os.FileInfo) bool) error {
+	fi, err := os.Lstat(name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if cond(fi) {
+		return os.Remove(name)
+	}
+	return nil
+}
+
+// isNotEmpty 是否为目录非空错误
+func isNotEmpty(err error) bool {
+	return errors.Is(err, syscall.ENOTEMPTY) || errors.Is(err, syscall.EEXIST)
+}
+
+// InstallError 安装失败的错误，包含回滚的结果
+type InstallError struct {
+	Err         error // 安装失败的原因
+	RollbackErr error // 回滚失败的原因，为空表示回滚成功
+}
+
+func (e *InstallError) Error() string {
+	if e.RollbackErr != nil {
+		return fmt.Sprintf("%v; rollback fail: %v", e.Err, e.RollbackErr)
+	}
+	return fmt.Sprintf("%v; rollback succeeded", e.Err)
+}
+
+func (e *InstallError) Unwrap() error {
+	return e.Err
+}
+
+// RolledBack 是否已成功回滚
+func (e *InstallError) RolledBack() bool {
+	return e.RollbackErr == nil
+}
@@ -0,0 +1,329 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ruixiaoedu/ota/models"
+	"github.com/ruixiaoedu/ota/utils"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+)
+
+// Verify 校验安装根目录下已安装包拥有的路径，只返回有问题的路径
+// name为空时校验所有包，root为空时使用默认安装根目录；
+// repair为真时从缓存中修复缺失和被修改的路径，并删除镜像目录中多余的路径，本地修改过的配置文件不修复
+func (core *Core) Verify(name, root string, repair bool) ([]models.VerifyResult, error) {
+	core.mu.Lock()
+	defer core.mu.Unlock()
+
+	root, err := core.installRoot(&models.UpdateOptions{Root: root})
+	if err != nil {
+		return nil, err
+	}
+
+	pkgs, err := core.packages()
+	if err != nil {
+		return nil, err
+	}
+	var installed, selected []models.Package
+	for _, pkg := range pkgs {
+		if pkg.Root != root {
+			continue
+		}
+		installed = append(installed, pkg)
+		if name == "" || pkg.Name == name {
+			selected = append(selected, pkg)
+		}
+	}
+	if name != "" && len(selected) == 0 {
+		return nil, fmt.Errorf("package %s is not installed in %s", name, root)
+	}
+
+	results, err := verifyPackages(selected, installed)
+	if err != nil || !repair || len(results) == 0 {
+		return results, err
+	}
+
+	j := newJournal(core.journalPath(), "", root)
+	j.Operation = models.OperationRepair
+	j.Name = name
+	err = core.repair(j, selected, results)
+	if _, herr := core.recordHistory(j, false, err); herr != nil {
+		log.Println("record history fail", herr)
+	}
+	if jerr := j.remove(); jerr != nil {
+		log.Println("remove journal fail", jerr)
+	}
+
+	return results, err
+}
+
+// verifyPackages 校验包拥有的路径，installed为安装根目录下的所有包，用于查找镜像目录中多余的路径
+func verifyPackages(selected, installed []models.Package) ([]models.VerifyResult, error) {
+	var results []models.VerifyResult
+	for _, pkg := range selected {
+		for _, f := range pkg.Files {
+			if r := verifyInstalled(f); r != nil {
+				r.Package = pkg.Name
+				results = append(results, *r)
+			}
+		}
+	}
+
+	// 所有包拥有的路径及其上级目录都不是多余的
+	owned := make(map[string]struct{})
+	for _, pkg := range installed {
+		for _, f := range pkg.Files {
+			for name := f.Path; name != "/" && name != "."; name = filepath.Dir(name) {
+				owned[name] = struct{}{}
+			}
+		}
+	}
+
+	for _, pkg := range selected {
+		for _, f := range pkg.Files {
+			if !f.Mirror {
+				continue
+			}
+			err := filepath.Walk(f.Path, func(name string, fi os.FileInfo, err error) error {
+				if os.IsNotExist(err) {
+					return nil
+				} else if err != nil {
+					return err
+				}
+				if _, ok := owned[name]; ok {
+					return nil
+				}
+				results = append(results, models.VerifyResult{Package: pkg.Name, Path: name, Status: models.VerifyExtra})
+				if fi.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// verifyInstalled 校验单个路径，没有问题时返回空
+func verifyInstalled(f models.InstalledFile) *models.VerifyResult {
+	r := &models.VerifyResult{Path: f.Path, Status: models.VerifyModified, Conffile: f.Conffile}
+
+	fi, err := os.Lstat(f.Path)
+	if os.IsNotExist(err) {
+		r.Status = models.VerifyMissing
+		return r
+	} else if err != nil {
+		r.Details = append(r.Details, err.Error())
+		return r
+	}
+
+	var typ string
+	switch {
+	case fi.IsDir():
+		typ = models.FileDir
+	case fi.Mode()&os.ModeSymlink != 0:
+		typ = models.FileSymlink
+	case fi.Mode().IsRegular():
+		typ = models.FileRegular
+	}
+	// 硬链接本身是普通文件
+	if typ != f.Type && !(f.Type == models.FileHardlink && typ == models.FileRegular) {
+		r.Details = append(r.Details, "type")
+		return r
+	}
+
+	switch f.Type {
+	case models.FileRegular:
+		if f.Sha256 != "" {
+			if modified, err := changed(f.Path, f.Sha256); err != nil || modified {
+				r.Details = append(r.Details, "sha256")
+			}
+		}
+	case models.FileSymlink:
+		if target, err := os.Readlink(f.Path); err != nil || target != f.Target {
+			r.Details = append(r.Details, "target")
+		}
+	case models.FileHardlink:
+		if ti, err := os.Lstat(f.Target); err != nil || !os.SameFile(fi, ti) {
+			r.Details = append(r.Details, "target")
+		}
+	}
+
+	if f.Mode != "" && f.Type != models.FileSymlink {
+		if mode, err := parseMode(f.Mode); err == nil && fi.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky) != mode {
+			r.Details = append(r.Details, "mode")
+		}
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		if f.Uid != nil && int(st.Uid) != *f.Uid {
+			r.Details = append(r.Details, "owner")
+		}
+		if f.Gid != nil && int(st.Gid) != *f.Gid {
+			r.Details = append(r.Details, "group")
+		}
+	}
+	for name, value := range f.Xattrs {
+		want, err := parseXattrValue(value)
+		if err != nil {
+			continue
+		}
+		if current, err := getXattr(f.Path, name); err != nil || !bytes.Equal(current, want) {
+			r.Details = append(r.Details, "xattrs")
+			break
+		}
+	}
+
+	if len(r.Details) == 0 {
+		return nil
+	}
+	return r
+}
+
+// repair 在事务中修复校验结果中的路径，修复成功的结果标记为已修复
+func (core *Core) repair(j *journal, pkgs []models.Package, results []models.VerifyResult) error {
+	files := make(map[string]models.InstalledFile)
+	for _, pkg := range pkgs {
+		for _, f := range pkg.Files {
+			files[f.Path] = f
+		}
+	}
+
+	// 先删除多余的路径，再按安装顺序修复
+	rank := map[string]int{
+		models.FileDir:      1,
+		models.FileRegular:  2,
+		models.FileHardlink: 3,
+		models.FileSymlink:  4,
+	}
+	order := make([]int, len(results))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return rank[files[results[order[a]].Path].Type] < rank[files[results[order[b]].Path].Type]
+	})
+
+	tx, err := newTransaction(core.backupDir(), j)
+	if err != nil {
+		return err
+	}
+	core.onCommit(tx)
+
+	for _, i := range order {
+		r := &results[i]
+		switch {
+		case r.Conffile:
+			continue
+		case r.Status == models.VerifyExtra:
+			err = removePath(tx, r.Path)
+		default:
+			err = core.repairFile(tx, files[r.Path])
+		}
+		if err != nil {
+			return tx.abort(err)
+		}
+	}
+
+	// 重新校验，确认修复结果
+	for i := range results {
+		r := &results[i]
+		if r.Conffile {
+			continue
+		}
+		if r.Status == models.VerifyExtra {
+			r.Repaired = !utils.FileExist(r.Path)
+		} else {
+			r.Repaired = verifyInstalled(files[r.Path]) == nil
+		}
+	}
+
+	if err = j.step(stepPostinstalled); err != nil {
+		return tx.abort(err)
+	}
+	return tx.commit()
+}
+
+// repairFile 按数据库中的记录重新安装单个路径，缓存中没有内容的文件跳过并记录警告
+func (core *Core) repairFile(tx *transaction, f models.InstalledFile) error {
+	attrs := &fileAttrs{uid: -1, gid: -1}
+	if f.Mode != "" {
+		mode, err := parseMode(f.Mode)
+		if err != nil {
+			return err
+		}
+		attrs.mode = &mode
+	}
+	if f.Uid != nil {
+		attrs.uid = *f.Uid
+	}
+	if f.Gid != nil {
+		attrs.gid = *f.Gid
+	}
+	for name, value := range f.Xattrs {
+		bs, err := parseXattrValue(value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid xattr %s: %v", f.Path, name, err)
+		}
+		if attrs.xattrs == nil {
+			attrs.xattrs = make(map[string][]byte)
+		}
+		attrs.xattrs[name] = bs
+	}
+
+	if f.Type == models.FileRegular {
+		// 缓存内容可能已损坏，写入前重新校验
+		bad, err := changed(core.objectPath(f.Sha256), f.Sha256)
+		if os.IsNotExist(err) {
+			tx.warn(fmt.Sprintf("%s cannot be repaired, content is not cached", f.Path))
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if bad {
+			tx.warn(fmt.Sprintf("%s cannot be repaired, cached content is corrupted", f.Path))
+			if err = os.Remove(core.objectPath(f.Sha256)); err != nil {
+				log.Println("remove corrupted object fail", err)
+			}
+			return nil
+		}
+	}
+
+	// 类型不同的已有路径先删除
+	if fi, err := os.Lstat(f.Path); err == nil && fi.IsDir() != (f.Type == models.FileDir) {
+		if err = removePath(tx, f.Path); err != nil {
+			return err
+		}
+	}
+	if err := tx.backup(f.Path); err != nil {
+		return err
+	}
+
+	var err error
+	switch f.Type {
+	case models.FileDir:
+		err = installDir(f.Path, attrs)
+	case models.FileSymlink:
+		if err = utils.SymlinkAtomic(f.Target, f.Path); err == nil {
+			err = attrs.applyPath(f.Path, true)
+		}
+	case models.FileHardlink:
+		err = utils.LinkAtomic(f.Target, f.Path)
+	default:
+		if err = installFile(core.objectPath(f.Sha256), f.Path, attrs); err == nil {
+			err = attrs.applyXattrs(f.Path)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("repair %s fail: %v", f.Path, err)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"sort"
+	"strings"
+)
+
+// paxXattrPrefix tar包PAX头中扩展属性的前缀
+const paxXattrPrefix = "SCHILY.xattr."
+
+// parseXattrValue 解析描述文件中的扩展属性值，格式与getfattr相同：
+// 0x开头为十六进制，0s开头为base64，其余为文本
+func parseXattrValue(v string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(v, "0x") || strings.HasPrefix(v, "0X"):
+		return hex.DecodeString(v[2:])
+	case strings.HasPrefix(v, "0s") || strings.HasPrefix(v, "0S"):
+		return base64.StdEncoding.DecodeString(v[2:])
+	default:
+		return []byte(v), nil
+	}
+}
+
+// archiveXattrs 从tar包头中读取扩展属性，值按十六进制编码，与描述文件的格式一致
+func archiveXattrs(records map[string]string) map[string]string {
+	var m map[string]string
+	for k, v := range records {
+		if !strings.HasPrefix(k, paxXattrPrefix) {
+			continue
+		}
+		if m == nil {
+			m = make(map[string]string)
+		}
+		m[strings.TrimPrefix(k, paxXattrPrefix)] = "0x" + hex.EncodeToString([]byte(v))
+	}
+	return m
+}
+
+// listXattrs 读取路径上的扩展属性，不跟随符号链接，文件系统不支持时返回空
+func listXattrs(name string) (map[string][]byte, error) {
+	size, err := unix.Llistxattr(name, nil)
+	if err != nil {
+		if unsupported(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if size == 0 {
+		return nil, nil
+	}
+
+	buf := make([]byte, size)
+	if size, err = unix.Llistxattr(name, buf); err != nil {
+		return nil, err
+	}
+
+	m := make(map[string][]byte)
+	for _, attr := range bytes.Split(buf[:size], []byte{0}) {
+		if len(attr) == 0 {
+			continue
+		}
+		value, err := getXattr(name, string(attr))
+		if err != nil {
+			return nil, err
+		}
+		m[string(attr)] = value
+	}
+	return m, nil
+}
+
+// getXattr 读取单个扩展属性
+func getXattr(name, attr string) ([]byte, error) {
+	size, err := unix.Lgetxattr(name, attr, nil)
+	if err != nil {
+		return nil, err
+	}
+	value := make([]byte, size)
+	if size, err = unix.Lgetxattr(name, attr, value); err != nil {
+		return nil, err
+	}
+	return value[:size], nil
+}
+
+// setXattrs 按名称顺序设置扩展属性，不跟随符号链接
+func setXattrs(name string, m map[string][]byte) error {
+	attrs := make([]string, 0, len(m))
+	for k := range m {
+		attrs = append(attrs, k)
+	}
+	sort.Strings(attrs)
+
+	for _, k := range attrs {
+		if err := unix.Lsetxattr(name, k, m[k], 0); err != nil {
+			return fmt.Errorf("set xattr %s on %s fail: %v", k, name, err)
+		}
+	}
+	return nil
+}
+
+// resetXattrs 将扩展属性恢复为m，删除m中没有的属性
+func resetXattrs(name string, m map[string][]byte) error {
+	current, err := listXattrs(name)
+	if err != nil {
+		return err
+	}
+	for k := range current {
+		if _, ok := m[k]; ok {
+			continue
+		}
+		if err = unix.Lremovexattr(name, k); err != nil {
+			return fmt.Errorf("remove xattr %s on %s fail: %v", k, name, err)
+		}
+	}
+	return setXattrs(name, m)
+}
+
+// unsupported 是否为文件系统不支持扩展属性的错误
+func unsupported(err error) bool {
+	return errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP)
+}
@@ -1,15 +1,27 @@
 package interfaces
 
-import "io"
+import (
+	"github.com/ruixiaoedu/ota/models"
+	"io"
+)
 
 type Core interface {
 
 	// UpdateFromLocalFile 从本地文件中进行升级
-	UpdateFromLocalFile(filename string) error
+	UpdateFromLocalFile(filename string, opts *models.UpdateOptions) (*models.History, error)
 
 	// UpdateFromUrl 从网络进行升级
-	UpdateFromUrl(url string) error
+	UpdateFromUrl(url string, opts *models.UpdateOptions) (*models.History, error)
 
-	// Update OTA升级
-	Update(reader io.Reader) error
+	// Update OTA升级，返回本次升级的记录，opts为空时使用默认选项
+	Update(reader io.Reader, opts *models.UpdateOptions) (*models.History, error)
+
+	// Remove 卸载安装根目录下的包，root为空时使用默认安装根目录
+	Remove(name, root string) (*models.History, error)
+
+	// Verify 校验已安装包拥有的路径，name为空时校验所有包，repair为真时修复有问题的路径
+	Verify(name, root string, repair bool) ([]models.VerifyResult, error)
+
+	// Log 读取升级的脚本输出，id为空时读取最近一次升级
+	Log(id string) (string, error)
 }
@@ -7,18 +7,83 @@ type Description struct {
 	Reboot      bool     `json:"reboot"`
 	Files       []File   `json:"files"`
 	Scripts     []Script `json:"scripts"`
+	Remove      []string `json:"remove,omitempty"` // 升级时删除的路径，目录连同其内容一起删除
+
+	ArchiveTypes []string `json:"archive_types,omitempty"` // tar包中允许的普通文件和目录之外的条目类型
 }
 
 type File struct {
-	Filename string `json:"filename"`
-	Path     string `json:"path"`
-	Md5      string `json:"md5"`
-	Sha256   string `json:"sha256"`
+	Filename string            `json:"filename"`
+	Path     string            `json:"path"`
+	Md5      string            `json:"md5"`
+	Sha256   string            `json:"sha256"`
+	Size     int64             `json:"size,omitempty"`     // 普通文件的大小（字节），为0时不检查
+	Type     string            `json:"type,omitempty"`     // 类型，为空时为普通文件，非普通文件没有filename
+	Target   string            `json:"target,omitempty"`   // 符号链接指向的目标，或硬链接指向的已安装路径
+	Mode     string            `json:"mode,omitempty"`     // 八进制权限，如"0755"，为空时沿用原文件的权限
+	Uid      *int              `json:"uid,omitempty"`      // 所有者ID，与owner二选一
+	Owner    string            `json:"owner,omitempty"`    // 所有者名称
+	Gid      *int              `json:"gid,omitempty"`      // 所属组ID，与group二选一
+	Group    string            `json:"group,omitempty"`    // 所属组名称
+	Mtime    int64             `json:"mtime,omitempty"`    // 修改时间（Unix时间戳，秒）
+	Mirror   bool              `json:"mirror,omitempty"`   // 目录镜像模式，删除目录中不属于升级包的内容
+	Xattrs   map[string]string `json:"xattrs,omitempty"`   // 扩展属性，值的格式与getfattr相同，0x开头为十六进制，0s开头为base64
+	Conffile bool              `json:"conffile,omitempty"` // 配置文件，本地修改过时不覆盖，新版本另存为<path>.ota-new
 }
 
+// 文件类型
+const (
+	FileRegular  = "file"     // 普通文件
+	FileDir      = "dir"      // 目录
+	FileSymlink  = "symlink"  // 符号链接
+	FileHardlink = "hardlink" // 硬链接
+)
+
 type Script struct {
-	Filename string `json:"filename"`
-	Type     string `json:"type"`
-	Md5      string `json:"md5"`
-	Sha256   string `json:"sha256"`
+	Filename    string   `json:"filename"`
+	Type        string   `json:"type"`
+	Md5         string   `json:"md5"`
+	Sha256      string   `json:"sha256"`
+	OnFailure   string   `json:"on_failure,omitempty"`  // 失败时的处理策略
+	Timeout     int      `json:"timeout,omitempty"`     // 超时时间（秒），为0时使用全局配置
+	Interpreter string   `json:"interpreter,omitempty"` // 解释器，为空时直接执行带#!的脚本，否则使用/bin/sh
+	Args        []string `json:"args,omitempty"`        // 脚本参数
+	Content     string   `json:"content,omitempty"`     // 内联脚本内容，与filename二选一
+	User        string   `json:"user,omitempty"`        // 运行脚本的用户，为空时使用全局配置
+	Group       string   `json:"group,omitempty"`       // 运行脚本的用户组，为空时使用用户的主组
+	Limits      *Limits  `json:"limits,omitempty"`      // 资源限制，未设置的项使用全局配置
+	PrivateTmp  *bool    `json:"private_tmp,omitempty"` // 是否使用独立的临时目录，为空时使用全局配置
+}
+
+// Limits 脚本的资源限制，为0表示不限制
+type Limits struct {
+	Cpu       uint64 `json:"cpu,omitempty"`       // CPU时间（秒）
+	Memory    uint64 `json:"memory,omitempty"`    // 虚拟内存（字节）
+	Files     uint64 `json:"files,omitempty"`     // 打开的文件数
+	Processes uint64 `json:"processes,omitempty"` // 用户的进程数
 }
+
+// tar包中的其他条目类型，符号链接和硬链接与文件类型相同
+const (
+	EntryChar  = "char"  // 字符设备
+	EntryBlock = "block" // 块设备
+	EntryFifo  = "fifo"  // 命名管道
+)
+
+// 脚本类型
+const (
+	ScriptPrecheck    = "precheck"    // 修改任何内容之前执行，失败时拒绝升级
+	ScriptPreinstall  = "preinstall"  // 复制文件之前执行
+	ScriptPostinstall = "postinstall" // 复制文件之后执行
+	ScriptVerify      = "verify"      // 安装完成后执行，校验安装结果，失败时回滚
+	ScriptRollback    = "rollback"    // 回滚完成后执行
+	ScriptPreremove   = "preremove"   // 卸载之前执行
+	ScriptPostremove  = "postremove"  // 卸载之后执行
+)
+
+// 脚本失败时的处理策略
+const (
+	OnFailureAbort    = "abort"    // 终止升级，不回滚已安装的文件
+	OnFailureIgnore   = "ignore"   // 忽略失败，继续升级
+	OnFailureRollback = "rollback" // 终止升级并回滚
+)
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// 操作类型
+const (
+	OperationUpdate = ""       // 升级
+	OperationRemove = "remove" // 卸载
+	OperationRepair = "repair" // 修复
+)
+
+// History 升级记录
+type History struct {
+	Id         string    `json:"id"`          // 升级ID，用于查询脚本输出
+	Time       time.Time `json:"time"`        // 完成时间
+	Name       string    `json:"name"`        // 包名称
+	Version    string    `json:"version"`     // 包版本
+	Root       string    `json:"root"`        // 安装根目录
+	Operation  string    `json:"operation"`   // 操作类型，为空时为升级
+	Ok         bool      `json:"ok"`          // 是否升级成功
+	RolledBack bool      `json:"rolled_back"` // 失败后是否已回滚
+	Recovered  bool      `json:"recovered"`   // 是否为启动时恢复的中断升级
+	Message    string    `json:"message"`     // 失败原因
+	Warnings   []string  `json:"warnings"`    // 升级成功但需要注意的问题，如未覆盖的配置文件
+}
@@ -0,0 +1,7 @@
+package models
+
+// UpdateOptions 单次升级的选项
+type UpdateOptions struct {
+	Root  string // 安装根目录，为空时使用配置文件中的设置
+	Force bool   // 是否强制接管其他包拥有的路径
+}
@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+// Package 已安装的包
+type Package struct {
+	Name        string          `json:"name"`         // 包名称
+	Version     string          `json:"version"`      // 已安装的版本
+	Root        string          `json:"root"`         // 安装根目录
+	InstalledAt time.Time       `json:"installed_at"` // 安装时间
+	Files       []InstalledFile `json:"files"`        // 包拥有的路径
+	Scripts     []Script        `json:"scripts"`      // 卸载时执行的脚本
+	ScriptDir   string          `json:"script_dir"`   // 卸载脚本的保存目录，也是脚本的工作目录
+
+	Dirs         []string `json:"dirs,omitempty"`          // 安装时新建的上级目录，卸载时为空则删除
+	NewConffiles []string `json:"new_conffiles,omitempty"` // 另存的新版本配置文件，卸载时未修改则删除
+}
+
+// InstalledFile 已安装包拥有的路径
+type InstalledFile struct {
+	Path     string `json:"path"`               // 安装路径，已在安装根目录下解析
+	Type     string `json:"type"`               // 类型，与File.Type相同
+	Sha256   string `json:"sha256,omitempty"`   // 普通文件安装时的SHA256
+	Target   string `json:"target,omitempty"`   // 链接的目标
+	Conffile bool   `json:"conffile,omitempty"` // 是否为配置文件
+	Mirror   bool   `json:"mirror,omitempty"`   // 是否为镜像目录
+	Mode     string `json:"mode,omitempty"`     // 声明的权限，为空时不校验
+	Uid      *int   `json:"uid,omitempty"`      // 声明的所有者，为空时不校验
+	Gid      *int   `json:"gid,omitempty"`      // 声明的所属组，为空时不校验
+
+	Xattrs map[string]string `json:"xattrs,omitempty"` // 声明的扩展属性，格式与File.Xattrs相同
+}
+
+// 校验结果的状态
+const (
+	VerifyMissing  = "missing"  // 路径不存在
+	VerifyModified = "modified" // 与声明的不一致
+	VerifyExtra    = "extra"    // 镜像目录中不属于任何包的路径
+)
+
+// VerifyResult 已安装路径的校验结果，只包含有问题的路径
+type VerifyResult struct {
+	Package  string   `json:"package"`            // 包名称
+	Path     string   `json:"path"`               // 路径
+	Status   string   `json:"status"`             // 状态
+	Details  []string `json:"details,omitempty"`  // 不一致的项：type、sha256、mode、owner、group、target
+	Conffile bool     `json:"conffile,omitempty"` // 是否为配置文件，配置文件的修改不会被修复
+	Repaired bool     `json:"repaired,omitempty"` // 是否已修复
+}
@@ -0,0 +1,235 @@
+package test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/ruixiaoedu/ota/config"
+	"github.com/ruixiaoedu/ota/core"
+	"github.com/ruixiaoedu/ota/models"
+	"github.com/ruixiaoedu/ota/utils"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// buildRawPackage 生成只包含描述文件和给定tar头的升级包
+func buildRawPackage(t *testing.T, des models.Description, headers []*tar.Header) *bytes.Buffer {
+	t.Helper()
+
+	bs, err := json.Marshal(des)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	headers = append([]*tar.Header{{Name: "ota-description.json", Mode: 0644, Size: int64(len(bs)), Typeflag: tar.TypeReg}}, headers...)
+	for i, hdr := range headers {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			_, err = tw.Write(bs)
+		} else if hdr.Size > 0 {
+			_, err = tw.Write(bytes.Repeat([]byte("x"), int(hdr.Size)))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+// TestUnsafeArchive 测试拒绝不安全的tar包条目
+func TestUnsafeArchive(t *testing.T) {
+	dir := t.TempDir()
+	stateDir := filepath.Join(dir, "state")
+	c := core.NewCore(&config.Config{StateDir: stateDir})
+	des := models.Description{Name: "app", Version: "1.0"}
+
+	cases := map[string][]*tar.Header{
+		"traversal": {{Name: "../escape", Mode: 0644, Size: 1, Typeflag: tar.TypeReg}},
+		"nested":    {{Name: "a/../../../escape", Mode: 0644, Size: 1, Typeflag: tar.TypeReg}},
+		"absolute":  {{Name: filepath.Join(dir, "escape"), Mode: 0644, Size: 1, Typeflag: tar.TypeReg}},
+		"duplicate": {
+			{Name: "app", Mode: 0644, Size: 1, Typeflag: tar.TypeReg},
+			{Name: "./app", Mode: 0644, Size: 1, Typeflag: tar.TypeReg},
+		},
+		"undeclared": {{Name: "link", Linkname: "app", Typeflag: tar.TypeSymlink}},
+		"fifo":       {{Name: "fifo", Mode: 0644, Typeflag: tar.TypeFifo}},
+		"device":     {{Name: "null", Mode: 0644, Typeflag: tar.TypeChar, Devmajor: 1, Devminor: 3}},
+	}
+	for name, headers := range cases {
+		if _, err := c.Update(buildRawPackage(t, des, headers), nil); err == nil {
+			t.Fatalf("%s: unsafe archive is accepted", name)
+		}
+	}
+	for _, v := range []string{filepath.Join(dir, "escape"), filepath.Join(stateDir, "escape")} {
+		if utils.FileExist(v) {
+			t.Fatalf("%s is written outside the package directory", v)
+		}
+	}
+
+	// 声明后的符号链接只能指向包内
+	des.ArchiveTypes = []string{models.FileSymlink}
+	des.Files = []models.File{{Filename: "app", Path: filepath.Join(dir, "app")}}
+	escape := []*tar.Header{{Name: "link", Linkname: "../../escape", Typeflag: tar.TypeSymlink}}
+	if _, err := c.Update(buildRawPackage(t, des, escape), nil); err == nil {
+		t.Fatal("symlink escaping the package directory is accepted")
+	}
+	link := []*tar.Header{
+		{Name: "./", Mode: 0755, Typeflag: tar.TypeDir},
+		{Name: "app", Mode: 0644, Size: 1, Typeflag: tar.TypeReg},
+		{Name: "link", Linkname: "app", Typeflag: tar.TypeSymlink},
+	}
+	if _, err := c.Update(buildRawPackage(t, des, link), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestArchiveLimits 测试升级包的大小和数量限制
+func TestArchiveLimits(t *testing.T) {
+	dir := t.TempDir()
+	c := core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state"), MaxPackageSize: 4096, MaxEntrySize: 1024, MaxEntries: 3})
+	des := models.Description{Name: "app", Version: "1.0"}
+
+	cases := map[string][]*tar.Header{
+		"entry": {{Name: "big", Mode: 0644, Size: 2048, Typeflag: tar.TypeReg}},
+		"total": {
+			{Name: "a", Mode: 0644, Size: 1024, Typeflag: tar.TypeReg},
+			{Name: "b", Mode: 0644, Size: 1024, Typeflag: tar.TypeReg},
+			{Name: "c", Mode: 0644, Size: 1024, Typeflag: tar.TypeReg},
+			{Name: "d", Mode: 0644, Size: 1024, Typeflag: tar.TypeReg},
+		},
+		"entries": {
+			{Name: "a", Mode: 0644, Typeflag: tar.TypeReg},
+			{Name: "b", Mode: 0644, Typeflag: tar.TypeReg},
+			{Name: "c", Mode: 0644, Typeflag: tar.TypeReg},
+		},
+	}
+	for name, headers := range cases {
+		if _, err := c.Update(buildRawPackage(t, des, headers), nil); err == nil {
+			t.Fatalf("%s: package over the limit is accepted", name)
+		}
+	}
+
+	// 声明的大小与实际不符
+	app := filepath.Join(dir, "app")
+	des.Files = []models.File{{Filename: "app", Path: app, Size: 10}}
+	if _, err := c.Update(buildPackage(t, des, map[string]string{"app": "binary"}, false), nil); err == nil {
+		t.Fatal("file with wrong size is accepted")
+	}
+	des.Files[0].Size = 6
+	if _, err := c.Update(buildPackage(t, des, map[string]string{"app": "binary"}, false), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestManifestFirst 测试描述文件必须位于升级包开头，其后的文件边解压边校验
+func TestManifestFirst(t *testing.T) {
+	dir := t.TempDir()
+	app := filepath.Join(dir, "app")
+	des := models.Description{Name: "app", Version: "1.0", Files: []models.File{{Filename: "app", Path: app}}}
+	des.Files[0].Sha256, _ = utils.Sha256FromReader(bytes.NewBufferString("x"))
+
+	bs, err := json.Marshal(des)
+	if err != nil {
+		t.Fatal(err)
+	}
+	late := []*tar.Header{
+		{Name: "app", Mode: 0644, Size: 1, Typeflag: tar.TypeReg},
+		{Name: "ota-description.json", Mode: 0644, Size: int64(len(bs)), Typeflag: tar.TypeReg},
+	}
+	build := func() *bytes.Buffer {
+		buf := &bytes.Buffer{}
+		gw := gzip.NewWriter(buf)
+		tw := tar.NewWriter(gw)
+		for _, hdr := range late {
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+			data := []byte("x")
+			if hdr.Name == "ota-description.json" {
+				data = bs
+			}
+			if _, err := tw.Write(data); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tw.Close()
+		gw.Close()
+		return buf
+	}
+
+	c := core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state")})
+	if _, err := c.Update(build(), nil); err == nil || !strings.Contains(err.Error(), "before ota-description.json") {
+		t.Fatalf("package with late description is accepted: %v", err)
+	}
+
+	// 未列出或校验值不符的文件立即拒绝
+	unlisted := []*tar.Header{{Name: "other", Mode: 0644, Size: 1, Typeflag: tar.TypeReg}}
+	if _, err := c.Update(buildRawPackage(t, des, unlisted), nil); err == nil || !strings.Contains(err.Error(), "not listed") {
+		t.Fatalf("unlisted file is accepted: %v", err)
+	}
+	if _, err := c.Update(buildPackage(t, des, map[string]string{"app": "y"}, false), nil); err == nil || !strings.Contains(err.Error(), "sha256") {
+		t.Fatalf("mismatched file is accepted: %v", err)
+	}
+
+	// 旧格式需要在配置中开启
+	c = core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state"), LegacyLayout: true})
+	if _, err := c.Update(build(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if !utils.FileExist(app) {
+		t.Fatal("legacy package is not installed")
+	}
+}
+
+// TestSpecialEntryAsFile 测试声明的特殊条目不能作为普通文件安装
+func TestSpecialEntryAsFile(t *testing.T) {
+	dir := t.TempDir()
+	des := models.Description{
+		Name:         "app",
+		Version:      "1.0",
+		ArchiveTypes: []string{models.EntryFifo, models.FileSymlink},
+	}
+	fifo := []*tar.Header{{Name: "pipe", Mode: 0644, Typeflag: tar.TypeFifo}}
+	link := []*tar.Header{{Name: "pipe", Linkname: "other", Typeflag: tar.TypeSymlink}}
+
+	for _, legacy := range []bool{false, true} {
+		c := core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state"), LegacyLayout: legacy})
+		for _, typ := range []string{"file", "script"} {
+			des.Files, des.Scripts = nil, nil
+			if typ == "file" {
+				des.Files = []models.File{{Filename: "pipe", Path: filepath.Join(dir, "app")}}
+			} else {
+				des.Scripts = []models.Script{{Filename: "pipe", Type: models.ScriptPreinstall}}
+			}
+			for _, headers := range [][]*tar.Header{fifo, link} {
+				done := make(chan error, 1)
+				go func() {
+					_, err := c.Update(buildRawPackage(t, des, headers), nil)
+					done <- err
+				}()
+				select {
+				case err := <-done:
+					if err == nil {
+						t.Fatalf("%s %s is accepted as a %s", headers[0].Name, string(headers[0].Typeflag), typ)
+					}
+				case <-time.After(10 * time.Second):
+					t.Fatal("update hangs on a special entry")
+				}
+			}
+		}
+	}
+}
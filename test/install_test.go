@@ -0,0 +1,744 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/ruixiaoedu/ota/config"
+	"github.com/ruixiaoedu/ota/core"
+	"github.com/ruixiaoedu/ota/models"
+	"github.com/ruixiaoedu/ota/utils"
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestAtomicReplace 测试文件原子替换
+func TestAtomicReplace(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "bin", "app")
+
+	c := core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state")})
+	for _, content := range []string{"version 1", "version 2"} {
+		pkg := buildPackage(t, models.Description{
+			Name:    "app",
+			Version: content,
+			Files:   []models.File{{Filename: "app", Path: target}},
+		}, map[string]string{"app": content}, false)
+
+		if _, err := c.Update(pkg, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		bs, err := ioutil.ReadFile(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(bs) != content {
+			t.Fatalf("content is %q, want %q", bs, content)
+		}
+	}
+
+	// 目录中不应残留临时文件
+	entries, err := ioutil.ReadDir(filepath.Dir(target))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("unexpected files left in %s: %d", filepath.Dir(target), len(entries))
+	}
+}
+
+// TestPreserveAttrs 测试替换文件时沿用原文件的所有者、权限和扩展属性
+func TestPreserveAttrs(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("need root to change owner")
+	}
+	dir := t.TempDir()
+	target := filepath.Join(dir, "app.conf")
+	if err := ioutil.WriteFile(target, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(target, 1234, 4321); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(target, 0640|os.ModeSetgid); err != nil {
+		t.Fatal(err)
+	}
+	xattrs := unix.Setxattr(target, "user.test", []byte("keep"), 0) == nil
+
+	c := core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state"), AllowSetuid: true})
+	pkg := buildPackage(t, models.Description{
+		Name:    "app",
+		Version: "1.0",
+		Files:   []models.File{{Filename: "app.conf", Path: target}},
+	}, map[string]string{"app.conf": "new"}, false)
+	if _, err := c.Update(pkg, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var st unix.Stat_t
+	if err := unix.Stat(target, &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Uid != 1234 || st.Gid != 4321 || st.Mode&07777 != 02640 {
+		t.Fatalf("attrs are not kept: uid %d gid %d mode %o", st.Uid, st.Gid, st.Mode&07777)
+	}
+	if xattrs {
+		buf := make([]byte, 16)
+		n, err := unix.Getxattr(target, "user.test", buf)
+		if err != nil || string(buf[:n]) != "keep" {
+			t.Fatalf("xattr is not kept: %q %v", buf[:n], err)
+		}
+	}
+}
+
+// TestRollback 测试安装失败时回滚
+func TestRollback(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "etc", "app.conf")
+	created := filepath.Join(dir, "lib", "app", "plugin.so")
+	blocker := filepath.Join(dir, "blocker")
+
+	if err := os.MkdirAll(filepath.Dir(existing), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(existing, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(blocker, []byte("file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 第三个文件的上级路径是普通文件，复制时必定失败
+	pkg := buildPackage(t, models.Description{
+		Name:    "app",
+		Version: "2.0.0",
+		Files: []models.File{
+			{Filename: "app.conf", Path: existing},
+			{Filename: "plugin.so", Path: created},
+			{Filename: "broken", Path: filepath.Join(blocker, "broken")},
+		},
+	}, map[string]string{"app.conf": "new", "plugin.so": "plugin", "broken": "broken"}, false)
+
+	c := core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state")})
+	_, err := c.Update(pkg, nil)
+
+	var installErr *core.InstallError
+	if !errors.As(err, &installErr) {
+		t.Fatalf("want InstallError, got %v", err)
+	}
+	if !installErr.RolledBack() {
+		t.Fatalf("rollback fail: %v", installErr.RollbackErr)
+	}
+
+	bs, err := ioutil.ReadFile(existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != "old" {
+		t.Fatalf("%s is %q after rollback", existing, bs)
+	}
+	if fi, _ := os.Stat(existing); fi.Mode().Perm() != 0600 {
+		t.Fatalf("%s mode is %v after rollback", existing, fi.Mode())
+	}
+	if _, err = os.Stat(filepath.Join(dir, "lib")); !os.IsNotExist(err) {
+		t.Fatalf("created directory is not removed: %v", err)
+	}
+}
+
+// TestFileAttrs 测试设置安装文件的权限、所有者和修改时间
+func TestFileAttrs(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "bin", "app")
+	secret := filepath.Join(dir, "etc", "secret")
+	uid, gid := os.Getuid(), os.Getgid()
+
+	pkg := buildPackage(t, models.Description{
+		Name:    "app",
+		Version: "1.0.0",
+		Files: []models.File{
+			{Filename: "app", Path: bin, Mode: "4755", Mtime: 1600000000},
+			{Filename: "secret", Path: secret, Mode: "0600", Uid: &uid, Gid: &gid},
+		},
+	}, map[string]string{"app": "app", "secret": "secret"}, false)
+
+	// setuid位需要在路径策略中允许
+	c := core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state"), AllowSetuid: true})
+	if _, err := c.Update(pkg, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode() != os.ModeSetuid|0755 {
+		t.Fatalf("%s mode is %v", bin, fi.Mode())
+	}
+	if fi.ModTime().Unix() != 1600000000 {
+		t.Fatalf("%s mtime is %v", bin, fi.ModTime())
+	}
+
+	if fi, err = os.Stat(secret); err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode() != 0600 {
+		t.Fatalf("%s mode is %v", secret, fi.Mode())
+	}
+
+	// 无效的权限在修改任何文件之前被拒绝
+	pkg = buildPackage(t, models.Description{
+		Name:    "app",
+		Version: "2.0.0",
+		Files:   []models.File{{Filename: "app", Path: bin, Mode: "0999"}},
+	}, map[string]string{"app": "app 2"}, false)
+	if _, err = c.Update(pkg, nil); err == nil {
+		t.Fatal("invalid mode is accepted")
+	}
+}
+
+// TestLinksAndDirs 测试目录、符号链接和硬链接条目
+func TestLinksAndDirs(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "opt", "app")
+	bin := filepath.Join(root, "bin", "app-1.0")
+	current := filepath.Join(root, "bin", "app")
+	alias := filepath.Join(root, "bin", "app-alias")
+	data := filepath.Join(root, "data")
+
+	c := core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state")})
+	pkg := buildPackage(t, models.Description{
+		Name:    "app",
+		Version: "1.0",
+		Files: []models.File{
+			{Path: current, Type: models.FileSymlink, Target: "app-1.0"},
+			{Path: alias, Type: models.FileHardlink, Target: bin},
+			{Filename: "app", Path: bin},
+			{Path: data, Type: models.FileDir, Mode: "0700"},
+		},
+	}, map[string]string{"app": "binary"}, false)
+
+	if _, err := c.Update(pkg, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if target, err := os.Readlink(current); err != nil || target != "app-1.0" {
+		t.Fatalf("symlink target is %q, %v", target, err)
+	}
+	fi, err := os.Stat(alias)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bi, err := os.Stat(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(fi, bi) {
+		t.Fatal("hardlink does not share the inode of its target")
+	}
+	if di, err := os.Stat(data); err != nil || !di.IsDir() || di.Mode().Perm() != 0700 {
+		t.Fatalf("data dir is %v, %v", di, err)
+	}
+
+	// 目录不能被普通文件替换，失败时不修改任何内容
+	pkg = buildPackage(t, models.Description{
+		Name:    "app",
+		Version: "2.0",
+		Files: []models.File{
+			{Filename: "app", Path: bin},
+			{Filename: "data", Path: data},
+		},
+	}, map[string]string{"app": "binary 2", "data": "data"}, false)
+
+	if _, err := c.Update(pkg, nil); err == nil {
+		t.Fatal("replacing a directory with a file should fail")
+	}
+	if bs, err := ioutil.ReadFile(bin); err != nil || string(bs) != "binary" {
+		t.Fatalf("content is %q, %v", bs, err)
+	}
+
+	// 失败回滚时恢复原来的符号链接
+	pkg = buildPackage(t, models.Description{
+		Name:    "app",
+		Version: "3.0",
+		Files: []models.File{
+			{Path: current, Type: models.FileSymlink, Target: "app-3.0"},
+			{Path: filepath.Join(alias, "broken"), Type: models.FileSymlink, Target: "app-3.0"},
+		},
+	}, nil, false)
+
+	_, err = c.Update(pkg, nil)
+	var installErr *core.InstallError
+	if !errors.As(err, &installErr) || !installErr.RolledBack() {
+		t.Fatalf("want rolled back InstallError, got %v", err)
+	}
+	if target, err := os.Readlink(current); err != nil || target != "app-1.0" {
+		t.Fatalf("symlink target is %q after rollback, %v", target, err)
+	}
+}
+
+// TestRemoveAndMirror 测试删除旧文件和目录镜像模式
+func TestRemoveAndMirror(t *testing.T) {
+	dir := t.TempDir()
+	plugins := filepath.Join(dir, "plugins")
+	old := filepath.Join(dir, "old")
+
+	for name, content := range map[string]string{
+		filepath.Join(plugins, "a.so"):        "a",
+		filepath.Join(plugins, "b.so"):        "b",
+		filepath.Join(plugins, "sub", "c.so"): "c",
+		filepath.Join(old, "bin", "tool"):     "tool",
+	} {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshot := func() map[string]string {
+		m := make(map[string]string)
+		filepath.Walk(dir, func(name string, fi os.FileInfo, err error) error {
+			if err != nil || name == dir {
+				return err
+			}
+			if fi.IsDir() {
+				m[name] = "dir"
+			} else {
+				bs, _ := ioutil.ReadFile(name)
+				m[name] = string(bs)
+			}
+			return nil
+		})
+		return m
+	}
+	before := snapshot()
+
+	des := models.Description{
+		Name:    "app",
+		Version: "2.0",
+		Files: []models.File{
+			{Path: plugins, Type: models.FileDir, Mirror: true},
+			{Filename: "a.so", Path: filepath.Join(plugins, "a.so")},
+		},
+		Remove: []string{old},
+	}
+
+	// 失败时删除的内容全部恢复
+	failing := des
+	failing.Files = append(failing.Files, models.File{Path: filepath.Join(plugins, "a.so", "x"), Type: models.FileSymlink, Target: "x"})
+	c := core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state")})
+	if _, err := c.Update(buildPackage(t, failing, map[string]string{"a.so": "a2"}, false), nil); err == nil {
+		t.Fatal("update should fail")
+	}
+	os.RemoveAll(filepath.Join(dir, "state"))
+	after := snapshot()
+	if len(after) != len(before) {
+		t.Fatalf("rollback left %v, want %v", after, before)
+	}
+	for k, v := range before {
+		if after[k] != v {
+			t.Fatalf("%s is %q after rollback, want %q", k, after[k], v)
+		}
+	}
+
+	if _, err := c.Update(buildPackage(t, des, map[string]string{"a.so": "a2"}, false), nil); err != nil {
+		t.Fatal(err)
+	}
+	os.RemoveAll(filepath.Join(dir, "state"))
+	after = snapshot()
+	want := map[string]string{plugins: "dir", filepath.Join(plugins, "a.so"): "a2"}
+	if len(after) != len(want) {
+		t.Fatalf("tree is %v, want %v", after, want)
+	}
+	for k, v := range want {
+		if after[k] != v {
+			t.Fatalf("%s is %q, want %q", k, after[k], v)
+		}
+	}
+}
+
+// TestMirrorOtherPackages 测试镜像目录保留其他包拥有的文件
+func TestMirrorOtherPackages(t *testing.T) {
+	dir := t.TempDir()
+	plugins := filepath.Join(dir, "plugins")
+	b := filepath.Join(plugins, "b", "b.so")
+
+	c := core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state")})
+	pkg := buildPackage(t, models.Description{
+		Name:    "b",
+		Version: "1.0",
+		Files:   []models.File{{Filename: "b.so", Path: b}},
+	}, map[string]string{"b.so": "b"}, false)
+	if _, err := c.Update(pkg, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	pkg = buildPackage(t, models.Description{
+		Name:    "a",
+		Version: "1.0",
+		Files: []models.File{
+			{Path: plugins, Type: models.FileDir, Mirror: true},
+			{Filename: "a.so", Path: filepath.Join(plugins, "a.so")},
+		},
+	}, map[string]string{"a.so": "a"}, false)
+	if _, err := c.Update(pkg, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !utils.FileExist(b) {
+		t.Fatalf("%s of package b is removed by mirror", b)
+	}
+	results, err := c.Verify("b", "", false)
+	if err != nil || len(results) != 0 {
+		t.Fatalf("verify b: %v %v", results, err)
+	}
+}
+
+// TestRemoveOwnedPath 测试不能删除其他包拥有的路径
+func TestRemoveOwnedPath(t *testing.T) {
+	dir := t.TempDir()
+	tool := filepath.Join(dir, "opt", "a", "tool")
+
+	c := core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state")})
+	pkg := buildPackage(t, models.Description{
+		Name:    "a",
+		Version: "1.0",
+		Files:   []models.File{{Filename: "tool", Path: tool}},
+	}, map[string]string{"tool": "a"}, false)
+	if _, err := c.Update(pkg, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, remove := range []string{tool, filepath.Join(dir, "opt")} {
+		des := models.Description{Name: "b", Version: "1.0", Remove: []string{remove}}
+		if _, err := c.Update(buildPackage(t, des, nil, false), nil); err == nil || !strings.Contains(err.Error(), "owned by package a") {
+			t.Fatalf("%s: removing a path of another package is accepted: %v", remove, err)
+		}
+		if !utils.FileExist(tool) {
+			t.Fatalf("%s is removed", tool)
+		}
+	}
+
+	des := models.Description{Name: "b", Version: "1.0", Remove: []string{tool}}
+	if _, err := c.Update(buildPackage(t, des, nil, false), &models.UpdateOptions{Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	if utils.FileExist(tool) {
+		t.Fatalf("%s is not removed with force", tool)
+	}
+}
+
+// TestConffile 测试本地修改过的配置文件不被覆盖
+func TestConffile(t *testing.T) {
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "etc", "app.conf")
+
+	c := core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state")})
+	update := func(version, content string) *models.History {
+		t.Helper()
+		h, err := c.Update(buildPackage(t, models.Description{
+			Name:    "app",
+			Version: version,
+			Files:   []models.File{{Filename: "app.conf", Path: conf, Conffile: true}},
+		}, map[string]string{"app.conf": content}, false), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	read := func(name string) string {
+		t.Helper()
+		bs, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(bs)
+	}
+
+	// 未修改过的配置文件直接覆盖
+	update("1.0", "v1")
+	if h := update("2.0", "v2"); len(h.Warnings) != 0 || read(conf) != "v2" {
+		t.Fatalf("unmodified conffile is %q, warnings %v", read(conf), h.Warnings)
+	}
+
+	// 本地修改过的配置文件保留，新版本另存
+	if err := ioutil.WriteFile(conf, []byte("local"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h := update("3.0", "v3")
+	if read(conf) != "local" || read(conf+".ota-new") != "v3" {
+		t.Fatalf("conffile is %q, new version is %q", read(conf), read(conf+".ota-new"))
+	}
+	if len(h.Warnings) != 1 || !strings.Contains(h.Warnings[0], conf) {
+		t.Fatalf("unexpected warnings %v", h.Warnings)
+	}
+}
+
+// TestXattrs 测试描述文件和tar包头中的扩展属性
+func TestXattrs(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "bin", "app")
+	data := filepath.Join(dir, "data")
+
+	if err := os.MkdirAll(data, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Lsetxattr(data, "user.ota.keep", []byte("old"), 0); err != nil {
+		t.Skip("xattrs are not supported:", err)
+	}
+
+	getxattr := func(name, attr string) string {
+		buf := make([]byte, 256)
+		n, err := unix.Lgetxattr(name, attr, buf)
+		if err != nil {
+			return ""
+		}
+		return string(buf[:n])
+	}
+
+	c := core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state")})
+	des := models.Description{
+		Name:    "app",
+		Version: "1.0",
+		Files: []models.File{
+			{Filename: "app", Path: bin, Xattrs: map[string]string{"user.ota.hex": "0x6869", "user.ota.text": "text"}},
+			{Path: data, Type: models.FileDir, Xattrs: map[string]string{"user.ota.keep": "0sbmV3"}},
+		},
+	}
+	pax := map[string]map[string]string{
+		"app": {"SCHILY.xattr.user.ota.archive": "archive", "SCHILY.xattr.user.ota.text": "ignored"},
+	}
+
+	// 安装失败回滚时恢复原来的扩展属性
+	failing := des
+	failing.Files = append(failing.Files, models.File{Path: filepath.Join(bin, "broken"), Type: models.FileSymlink, Target: "x"})
+	if _, err := c.Update(buildPackageWithPAX(t, failing, map[string]string{"app": "app"}, false, pax), nil); err == nil {
+		t.Fatal("update should fail")
+	}
+	if v := getxattr(data, "user.ota.keep"); v != "old" {
+		t.Fatalf("user.ota.keep is %q after rollback", v)
+	}
+
+	if _, err := c.Update(buildPackageWithPAX(t, des, map[string]string{"app": "app"}, false, pax), nil); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		bin + "|user.ota.hex":     "hi",
+		bin + "|user.ota.text":    "text",
+		bin + "|user.ota.archive": "archive",
+		data + "|user.ota.keep":   "new",
+	} {
+		parts := strings.SplitN(name, "|", 2)
+		if v := getxattr(parts[0], parts[1]); v != want {
+			t.Fatalf("%s %s is %q, want %q", parts[0], parts[1], v, want)
+		}
+	}
+}
+
+// TestInstallRoot 测试在安装根目录下安装，路径不能通过..或符号链接逃逸
+func TestInstallRoot(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "rootfs")
+	outside := filepath.Join(dir, "outside")
+
+	for _, name := range []string{filepath.Join(root, "usr", "lib"), outside} {
+		if err := os.MkdirAll(name, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 绝对路径和相对路径的链接都指向根目录之外
+	if err := os.Symlink("/usr/lib", filepath.Join(root, "lib")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../../outside", filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"/etc/app.conf":           filepath.Join(root, "etc", "app.conf"),
+		"/../../etc/passwd":       filepath.Join(root, "etc", "passwd"),
+		"/lib/libapp.so":          filepath.Join(root, "usr", "lib", "libapp.so"),
+		"/escape/file":            filepath.Join(root, "outside", "file"),
+		"/usr/lib/../../lib":      filepath.Join(root, "lib"),
+		"/lib/../../../etc/hosts": filepath.Join(root, "etc", "hosts"),
+	} {
+		got, err := utils.ResolveInRoot(root, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("%s resolves to %s, want %s", name, got, want)
+		}
+	}
+
+	c := core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state"), Root: "/"})
+	pkg := buildPackage(t, models.Description{
+		Name:    "app",
+		Version: "1.0",
+		Files: []models.File{
+			{Filename: "libapp.so", Path: "/lib/libapp.so"},
+			{Filename: "file", Path: "/escape/file"},
+			{Path: "/lib/libapp.so.1", Type: models.FileHardlink, Target: "/lib/libapp.so"},
+		},
+		Scripts: []models.Script{{Type: models.ScriptPostinstall, Content: `test "$OTA_ROOT" = "` + root + `"`}},
+	}, map[string]string{"libapp.so": "lib", "file": "file"}, false)
+
+	if _, err := c.Update(pkg, &models.UpdateOptions{Root: root}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{
+		filepath.Join(root, "usr", "lib", "libapp.so"),
+		filepath.Join(root, "usr", "lib", "libapp.so.1"),
+		filepath.Join(root, "outside", "file"),
+	} {
+		if !utils.FileExist(name) {
+			t.Fatalf("%s is not installed", name)
+		}
+	}
+	if entries, _ := ioutil.ReadDir(outside); len(entries) != 0 {
+		t.Fatalf("files escaped the install root: %v", entries)
+	}
+}
+
+// TestPackageDatabase 测试已安装包数据库和路径所有权
+func TestPackageDatabase(t *testing.T) {
+	dir := t.TempDir()
+	shared := filepath.Join(dir, "bin", "tool")
+	stateDir := filepath.Join(dir, "state")
+
+	c := core.NewCore(&config.Config{StateDir: stateDir})
+	pkg := func(name string) *bytes.Buffer {
+		return buildPackage(t, models.Description{
+			Name:    name,
+			Version: "1.0",
+			Files: []models.File{
+				{Path: filepath.Dir(shared), Type: models.FileDir},
+				{Filename: "tool", Path: shared},
+			},
+		}, map[string]string{"tool": name}, false)
+	}
+	owners := func() map[string][]string {
+		bs, err := ioutil.ReadFile(filepath.Join(stateDir, "installed.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var pkgs []models.Package
+		if err = json.Unmarshal(bs, &pkgs); err != nil {
+			t.Fatal(err)
+		}
+		m := make(map[string][]string)
+		for _, p := range pkgs {
+			for _, f := range p.Files {
+				m[f.Path] = append(m[f.Path], p.Name)
+			}
+		}
+		return m
+	}
+
+	if _, err := c.Update(pkg("a"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if o := owners()[shared]; len(o) != 1 || o[0] != "a" {
+		t.Fatalf("%s is owned by %v", shared, o)
+	}
+
+	// 其他包声明同一路径时拒绝安装，除非强制接管
+	if _, err := c.Update(pkg("b"), nil); err == nil || !strings.Contains(err.Error(), "owned by package a") {
+		t.Fatalf("want ownership conflict, got %v", err)
+	}
+	if _, err := c.Update(pkg("b"), &models.UpdateOptions{Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	m := owners()
+	if o := m[shared]; len(o) != 1 || o[0] != "b" {
+		t.Fatalf("%s is owned by %v after takeover", shared, o)
+	}
+	if o := m[filepath.Dir(shared)]; len(o) != 2 {
+		t.Fatalf("directory %s should be shared, owned by %v", filepath.Dir(shared), o)
+	}
+}
+
+// TestPathPolicy 测试路径策略
+func TestPathPolicy(t *testing.T) {
+	dir := t.TempDir()
+	stateDir := filepath.Join(dir, "state")
+
+	c := core.NewCore(&config.Config{
+		StateDir:   stateDir,
+		AllowPaths: []string{filepath.Join(dir, "opt")},
+		DenyPaths:  []string{filepath.Join(dir, "opt", "keep")},
+	})
+
+	for _, tc := range []struct {
+		file   models.File
+		remove string
+		want   string
+	}{
+		{file: models.File{Filename: "f", Path: filepath.Join(dir, "etc", "shadow")}, want: "not allowed"},
+		{file: models.File{Filename: "f", Path: filepath.Join(dir, "opt", "keep", "f")}, want: "denied"},
+		{file: models.File{Filename: "f", Path: filepath.Join(stateDir, "installed.json")}, want: "denied"},
+		{file: models.File{Filename: "f", Path: filepath.Join(dir, "opt", "app"), Mode: "4755"}, want: "setuid"},
+		{file: models.File{Filename: "f", Path: filepath.Join(dir, "opt", "app"), Xattrs: map[string]string{"security.capability": "0x01"}}, want: "security.capability"},
+		{file: models.File{Path: filepath.Join(dir, "opt"), Type: models.FileDir, Mirror: true}, want: "contains"},
+		{file: models.File{Filename: "f", Path: filepath.Join(dir, "opt", "app")}, remove: filepath.Join(dir, "opt"), want: "contains"},
+	} {
+		des := models.Description{Name: "app", Version: "1.0", Files: []models.File{tc.file}}
+		if tc.remove != "" {
+			des.Remove = []string{tc.remove}
+		}
+		payload := map[string]string{}
+		if tc.file.Filename != "" {
+			payload[tc.file.Filename] = "f"
+		}
+		_, err := c.Update(buildPackage(t, des, payload, false), nil)
+		if err == nil || !strings.Contains(err.Error(), tc.want) || !strings.Contains(err.Error(), dir) {
+			t.Fatalf("%s: want error containing %q, got %v", tc.file.Path, tc.want, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "etc")); !os.IsNotExist(err) {
+		t.Fatal("denied update wrote files")
+	}
+
+	// 不能替换已有的setuid文件的内容，声明了不含setuid位的权限时可以
+	suid := filepath.Join(dir, "opt", "su")
+	if err := os.MkdirAll(filepath.Dir(suid), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(suid, []byte("root"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(suid, 0755|os.ModeSetuid); err != nil {
+		t.Fatal(err)
+	}
+	des := models.Description{Name: "su", Version: "1.0", Files: []models.File{{Filename: "f", Path: suid}}}
+	if _, err := c.Update(buildPackage(t, des, map[string]string{"f": "f"}, false), nil); err == nil || !strings.Contains(err.Error(), "setuid") {
+		t.Fatalf("replacing a setuid file is accepted: %v", err)
+	}
+	if bs, _ := ioutil.ReadFile(suid); string(bs) != "root" {
+		t.Fatalf("setuid file is replaced with %q", bs)
+	}
+	des.Files[0].Mode = "0755"
+	if _, err := c.Update(buildPackage(t, des, map[string]string{"f": "f"}, false), nil); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(suid); err != nil || fi.Mode()&os.ModeSetuid != 0 {
+		t.Fatalf("setuid bit is kept: %v", err)
+	}
+	os.Remove(suid)
+
+	// 未签名的升级包也不能通过tar包头设置security.*扩展属性
+	des = models.Description{Name: "app", Version: "1.0", Files: []models.File{{Filename: "f", Path: filepath.Join(dir, "opt", "app")}}}
+	pax := map[string]map[string]string{"f": {"SCHILY.xattr.security.capability": "\x01"}}
+	if _, err := c.Update(buildPackageWithPAX(t, des, map[string]string{"f": "f"}, false, pax), nil); err == nil || !strings.Contains(err.Error(), "security.capability") {
+		t.Fatalf("security xattr in archive header is accepted: %v", err)
+	}
+
+	if _, err := c.Update(buildPackage(t, des, map[string]string{"f": "f"}, false), nil); err != nil {
+		t.Fatal(err)
+	}
+}
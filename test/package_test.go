@@ -0,0 +1,94 @@
+package test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/ruixiaoedu/ota/models"
+	"github.com/ruixiaoedu/ota/utils"
+	"testing"
+)
+
+// buildPackage 根据描述和文件内容生成升级包，自动填充文件的SHA256
+func buildPackage(t *testing.T, des models.Description, payload map[string]string, sign bool) *bytes.Buffer {
+	t.Helper()
+	return buildPackageWithPAX(t, des, payload, sign, nil)
+}
+
+// buildPackageWithPAX 生成升级包，pax为文件在tar包头中的PAX记录
+func buildPackageWithPAX(t *testing.T, des models.Description, payload map[string]string, sign bool, pax map[string]map[string]string) *bytes.Buffer {
+	t.Helper()
+
+	des.Files = append([]models.File(nil), des.Files...)
+	des.Scripts = append([]models.Script(nil), des.Scripts...)
+	for i, v := range des.Files {
+		if content, ok := payload[v.Filename]; ok && des.Files[i].Sha256 == "" {
+			des.Files[i].Sha256, _ = utils.Sha256FromReader(bytes.NewBufferString(content))
+		}
+	}
+	for i, v := range des.Scripts {
+		if content, ok := payload[v.Filename]; ok && des.Scripts[i].Sha256 == "" {
+			des.Scripts[i].Sha256, _ = utils.Sha256FromReader(bytes.NewBufferString(content))
+		}
+	}
+
+	var signer func(data []byte) string
+	if sign {
+		prv, err := utils.ParsePrivateKey([]byte(privateKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer = func(data []byte) string {
+			sig, err := utils.SignWithSha256(data, prv)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return sig
+		}
+	}
+	return buildSignedPackage(t, des, payload, signer, pax)
+}
+
+// buildSignedPackage 生成升级包，signer不为空时用其返回值作为签名文件的内容，不填充SHA256
+func buildSignedPackage(t *testing.T, des models.Description, payload map[string]string, signer func(data []byte) string, pax map[string]map[string]string) *bytes.Buffer {
+	t.Helper()
+
+	bs, err := json.Marshal(des)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+
+	write := func(name string, data []byte, mode int64) {
+		hdr := &tar.Header{Name: name, Mode: mode, Size: int64(len(data)), Typeflag: tar.TypeReg, PAXRecords: pax[name]}
+		if pax[name] != nil {
+			hdr.Format = tar.FormatPAX
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("ota-description.json", bs, 0644)
+	if signer != nil {
+		write("ota-description.sig", []byte(signer(bs)), 0644)
+	}
+	for name, content := range payload {
+		write(name, []byte(content), 0755)
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
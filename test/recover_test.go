@@ -0,0 +1,140 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/ruixiaoedu/ota/config"
+	"github.com/ruixiaoedu/ota/core"
+	"github.com/ruixiaoedu/ota/models"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRecoverInterrupted 测试启动时回滚复制文件过程中中断的安装
+func TestRecoverInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	stateDir := filepath.Join(dir, "state")
+	replaced := filepath.Join(dir, "app")
+	created := filepath.Join(dir, "plugin.so")
+	backup := filepath.Join(stateDir, "backup", "0")
+
+	if err := os.MkdirAll(filepath.Dir(backup), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{replaced: "new", created: "new", backup: "old"} {
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 模拟复制第二个文件后进程退出时留下的安装日志，最后一条记录写入时中断
+	bs, _ := json.Marshal(map[string]interface{}{
+		"name":    "app",
+		"version": "2.0.0",
+		"package": filepath.Join(stateDir, "package"),
+		"step":    "installing",
+	})
+	if err := ioutil.WriteFile(filepath.Join(stateDir, "journal.json"), bs, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var entries []string
+	for _, e := range []map[string]interface{}{
+		{"backup": map[string]interface{}{"path": replaced, "backup": backup, "mode": 0644, "uid": os.Getuid(), "gid": os.Getgid()}},
+		{"commit": replaced},
+		{"backup": map[string]interface{}{"path": created}},
+		{"commit": created},
+	} {
+		bs, _ = json.Marshal(e)
+		entries = append(entries, string(bs))
+	}
+	torn := strings.Join(entries, "\n") + "\n" + `{"backup":{"path":"` + filepath.Join(dir, "missing")
+	if err := ioutil.WriteFile(filepath.Join(stateDir, "journal.entries"), []byte(torn), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := core.NewCore(&config.Config{StateDir: stateDir})
+	err := c.Recover()
+
+	var installErr *core.InstallError
+	if !errors.As(err, &installErr) || !installErr.RolledBack() {
+		t.Fatalf("want rolled back InstallError, got %v", err)
+	}
+
+	if bs, _ = ioutil.ReadFile(replaced); string(bs) != "old" {
+		t.Fatalf("%s is %q after recover", replaced, bs)
+	}
+	if _, err = os.Stat(created); !os.IsNotExist(err) {
+		t.Fatalf("%s is not removed: %v", created, err)
+	}
+	for _, name := range []string{"journal.json", "journal.entries"} {
+		if _, err = os.Stat(filepath.Join(stateDir, name)); !os.IsNotExist(err) {
+			t.Fatalf("%s is not removed: %v", name, err)
+		}
+	}
+
+	// 恢复结果写入升级记录
+	bs, err = ioutil.ReadFile(filepath.Join(stateDir, "history.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h models.History
+	if err = json.Unmarshal([]byte(strings.TrimSpace(string(bs))), &h); err != nil {
+		t.Fatal(err)
+	}
+	if h.Name != "app" || h.Ok || !h.Recovered || !h.RolledBack {
+		t.Fatalf("unexpected history: %+v", h)
+	}
+
+	// 没有安装日志时不做任何操作
+	if err = c.Recover(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestRecoverRepair 测试恢复中断的修复时不读取升级包
+func TestRecoverRepair(t *testing.T) {
+	dir := t.TempDir()
+	stateDir := filepath.Join(dir, "state")
+	repaired := filepath.Join(dir, "app")
+	backup := filepath.Join(stateDir, "backup", "0")
+
+	if err := os.MkdirAll(filepath.Dir(backup), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{repaired: "good", backup: "damaged"} {
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 文件已全部修复，尚未提交
+	bs, _ := json.Marshal(map[string]interface{}{
+		"name":      "app",
+		"operation": models.OperationRepair,
+		"root":      "/",
+		"step":      "installed",
+	})
+	if err := ioutil.WriteFile(filepath.Join(stateDir, "journal.json"), bs, 0644); err != nil {
+		t.Fatal(err)
+	}
+	bs, _ = json.Marshal(map[string]interface{}{
+		"backup": map[string]interface{}{"path": repaired, "backup": backup, "mode": 0644, "uid": os.Getuid(), "gid": os.Getgid()},
+	})
+	if err := ioutil.WriteFile(filepath.Join(stateDir, "journal.entries"), append(bs, '\n'), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := core.NewCore(&config.Config{StateDir: stateDir})
+	if err := c.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	if bs, _ = ioutil.ReadFile(repaired); string(bs) != "good" {
+		t.Fatalf("%s is %q after recover", repaired, bs)
+	}
+	if _, err := os.Stat(filepath.Join(stateDir, "backup")); !os.IsNotExist(err) {
+		t.Fatalf("backup is not removed: %v", err)
+	}
+}
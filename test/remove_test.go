@@ -0,0 +1,128 @@
+package test
+
+import (
+	"github.com/ruixiaoedu/ota/config"
+	"github.com/ruixiaoedu/ota/core"
+	"github.com/ruixiaoedu/ota/models"
+	"github.com/ruixiaoedu/ota/utils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRemove 测试卸载包
+func TestRemove(t *testing.T) {
+	dir := t.TempDir()
+	app := filepath.Join(dir, "opt", "app")
+	bin := filepath.Join(app, "bin", "app")
+	conf := filepath.Join(app, "app.conf")
+	marker := filepath.Join(dir, "marker")
+
+	c := core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state")})
+	pkg := buildPackage(t, models.Description{
+		Name:    "app",
+		Version: "1.0",
+		Files: []models.File{
+			{Path: app, Type: models.FileDir},
+			{Path: filepath.Dir(bin), Type: models.FileDir},
+			{Filename: "app", Path: bin},
+			{Filename: "app.conf", Path: conf, Conffile: true},
+		},
+		Scripts: []models.Script{
+			{Filename: "preremove.sh", Type: models.ScriptPreremove},
+			{Type: models.ScriptPostremove, Content: `echo "post $OTA_STAGE" >> ` + marker},
+		},
+	}, map[string]string{
+		"app":          "binary",
+		"app.conf":     "conf",
+		"preremove.sh": `test -f "` + bin + `" && echo "pre $OTA_VERSION" >> ` + marker,
+	}, false)
+
+	if _, err := c.Update(pkg, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(conf, []byte("local"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := c.Remove("app", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Operation != models.OperationRemove || len(h.Warnings) != 1 || !strings.Contains(h.Warnings[0], conf) {
+		t.Fatalf("unexpected history %+v", h)
+	}
+
+	// 本地修改过的配置文件保留，其余路径和空目录删除
+	if utils.FileExist(bin) || utils.FileExist(filepath.Dir(bin)) {
+		t.Fatal("files of the package are not removed")
+	}
+	if bs, err := ioutil.ReadFile(conf); err != nil || string(bs) != "local" {
+		t.Fatalf("modified conffile is %q, %v", bs, err)
+	}
+
+	bs, err := ioutil.ReadFile(marker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != "pre 1.0\npost postremove\n" {
+		t.Fatalf("scripts output is %q", bs)
+	}
+
+	if _, err = c.Remove("app", ""); err == nil {
+		t.Fatal("removing a package that is not installed should fail")
+	}
+	if entries, _ := ioutil.ReadDir(filepath.Join(dir, "state", "scripts")); len(entries) != 0 {
+		t.Fatalf("scripts of the removed package are left: %d", len(entries))
+	}
+	if _, err = os.Stat(app); err != nil {
+		t.Fatal("directory with a kept conffile should not be removed")
+	}
+}
+
+// TestRemoveCreatedPaths 测试卸载时删除安装时新建的上级目录和另存的配置文件
+func TestRemoveCreatedPaths(t *testing.T) {
+	dir := t.TempDir()
+	app := filepath.Join(dir, "opt", "app")
+	bin := filepath.Join(app, "bin", "app")
+	conf := filepath.Join(dir, "etc", "app", "app.conf")
+
+	c := core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state")})
+	update := func(version, content string) {
+		t.Helper()
+		pkg := buildPackage(t, models.Description{
+			Name:    "app",
+			Version: version,
+			Files: []models.File{
+				{Filename: "app", Path: bin},
+				{Filename: "app.conf", Path: conf, Conffile: true},
+			},
+		}, map[string]string{"app": "binary " + version, "app.conf": content}, false)
+		if _, err := c.Update(pkg, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	update("1.0", "conf 1")
+	if err := ioutil.WriteFile(conf, []byte("local"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	update("2.0", "conf 2")
+	if !utils.FileExist(conf + ".ota-new") {
+		t.Fatal("new conffile is not saved")
+	}
+
+	if _, err := c.Remove("app", ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{filepath.Join(dir, "opt"), conf + ".ota-new"} {
+		if utils.FileExist(name) {
+			t.Fatalf("%s is left after removal", name)
+		}
+	}
+	if bs, err := ioutil.ReadFile(conf); err != nil || string(bs) != "local" {
+		t.Fatalf("modified conffile is %q, %v", bs, err)
+	}
+}
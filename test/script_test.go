@@ -0,0 +1,413 @@
+package test
+
+import (
+	"errors"
+	"github.com/ruixiaoedu/ota/config"
+	"github.com/ruixiaoedu/ota/core"
+	"github.com/ruixiaoedu/ota/models"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestPreinstallAbort 测试预执行脚本失败时终止升级
+func TestPreinstallAbort(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "app")
+
+	pkg := buildPackage(t, models.Description{
+		Name:    "app",
+		Version: "1.0.0",
+		Files:   []models.File{{Filename: "app", Path: target}},
+		Scripts: []models.Script{{Filename: "check.sh", Type: "preinstall"}},
+	}, map[string]string{
+		"app":      "app",
+		"check.sh": "#!/bin/sh\necho checking\necho not enough space >&2\nexit 3\n",
+	}, false)
+
+	c := core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state")})
+	_, err := c.Update(pkg, nil)
+
+	var scriptErr *core.ScriptError
+	if !errors.As(err, &scriptErr) {
+		t.Fatalf("want ScriptError, got %v", err)
+	}
+	if scriptErr.ExitCode != 3 {
+		t.Fatalf("exit code is %d, want 3", scriptErr.ExitCode)
+	}
+	for _, s := range []string{"check.sh", "exit code 3", "not enough space"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Fatalf("message %q does not contain %q", err.Error(), s)
+		}
+	}
+	if _, err = os.Stat(target); !os.IsNotExist(err) {
+		t.Fatalf("%s is installed after preinstall failure", target)
+	}
+}
+
+// TestPostinstallPolicy 测试完成执行脚本的失败策略
+func TestPostinstallPolicy(t *testing.T) {
+	for policy, installed := range map[string]bool{
+		models.OnFailureIgnore:   true,
+		models.OnFailureAbort:    true,
+		models.OnFailureRollback: false,
+	} {
+		dir := t.TempDir()
+		target := filepath.Join(dir, "app")
+
+		pkg := buildPackage(t, models.Description{
+			Name:    "app",
+			Version: "1.0.0",
+			Files:   []models.File{{Filename: "app", Path: target}},
+			Scripts: []models.Script{{Filename: "post.sh", Type: "postinstall", OnFailure: policy}},
+		}, map[string]string{"app": "app", "post.sh": "exit 1\n"}, false)
+
+		c := core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state")})
+		_, err := c.Update(pkg, nil)
+		if (err == nil) != (policy == models.OnFailureIgnore) {
+			t.Fatalf("%s: unexpected result %v", policy, err)
+		}
+
+		bs, _ := ioutil.ReadFile(target)
+		if (string(bs) == "app") != installed {
+			t.Fatalf("%s: installed is %v, want %v", policy, !installed, installed)
+		}
+	}
+}
+
+// TestScriptTimeout 测试脚本超时后终止整个进程组
+func TestScriptTimeout(t *testing.T) {
+	dir := t.TempDir()
+	pidFile := filepath.Join(dir, "child.pid")
+
+	pkg := buildPackage(t, models.Description{
+		Name:    "app",
+		Version: "1.0.0",
+		Scripts: []models.Script{{Filename: "hang.sh", Type: "preinstall", Timeout: 1}},
+	}, map[string]string{
+		"hang.sh": "sleep 60 &\necho $! > " + pidFile + "\nwait\n",
+	}, false)
+
+	c := core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state")})
+	start := time.Now()
+	_, err := c.Update(pkg, nil)
+	if !errors.Is(err, core.ErrScriptTimeout) {
+		t.Fatalf("want ErrScriptTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("script is terminated after %v", elapsed)
+	}
+
+	// 脚本派生的子进程也应被终止
+	bs, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(bs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if running(pid) {
+		t.Fatalf("child process %d is still running", pid)
+	}
+}
+
+// TestScriptBackground 测试脚本启动后台进程后退出，升级不等待后台进程
+// 超时只计算脚本进程本身，后台进程持有输出管道不算超时，也不会被终止
+func TestScriptBackground(t *testing.T) {
+	dir := t.TempDir()
+	pidFile := filepath.Join(dir, "daemon.pid")
+
+	pkg := buildPackage(t, models.Description{
+		Name:    "app",
+		Version: "1.0.0",
+		Scripts: []models.Script{{Filename: "start.sh", Type: "postinstall", Timeout: 2}},
+	}, map[string]string{
+		"start.sh": "sleep 30 &\necho $! > " + pidFile + "\necho started\nexit 0\n",
+	}, false)
+
+	c := core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state")})
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Update(pkg, nil)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("update waits for the background process of the script")
+	}
+
+	bs, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(bs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Second)
+	if !running(pid) {
+		t.Fatalf("background process %d is terminated", pid)
+	}
+	syscall.Kill(pid, syscall.SIGKILL)
+}
+
+// running 进程是否仍在运行，已退出但未被回收的进程视为已退出
+func running(pid int) bool {
+	if err := syscall.Kill(pid, 0); err != nil {
+		return false
+	}
+	bs, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return true
+	}
+	fields := strings.Fields(string(bs)[strings.LastIndex(string(bs), ")")+1:])
+	return len(fields) == 0 || fields[0] != "Z"
+}
+
+// TestScriptEnv 测试脚本的运行环境
+func TestScriptEnv(t *testing.T) {
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, "env")
+	t.Setenv("OTA_TEST_LEAK", "1")
+
+	c := core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state")})
+	for _, version := range []string{"1.0.0", "2.0.0"} {
+		pkg := buildPackage(t, models.Description{
+			Name:    "app",
+			Version: version,
+			Reboot:  true,
+			Scripts: []models.Script{{Filename: "env.sh", Type: "postinstall"}},
+		}, map[string]string{
+			"env.sh": "env > " + envFile + "\necho PWD=$(pwd) >> " + envFile + "\n",
+		}, false)
+		if _, err := c.Update(pkg, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bs, err := ioutil.ReadFile(envFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := string(bs)
+	for _, s := range []string{
+		"OTA_NAME=app\n",
+		"OTA_VERSION=2.0.0\n",
+		"OTA_PREVIOUS_VERSION=1.0.0\n",
+		"OTA_STAGE=postinstall\n",
+		"OTA_REBOOT=1\n",
+		"OTA_ROOT=/\n",
+		"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin\n",
+		"PWD=" + filepath.Join(dir, "state", "package") + "\n",
+	} {
+		if !strings.Contains(env, s) {
+			t.Fatalf("environment does not contain %q:\n%s", s, env)
+		}
+	}
+	if strings.Contains(env, "OTA_TEST_LEAK") {
+		t.Fatalf("daemon environment is passed to script:\n%s", env)
+	}
+}
+
+// TestVerifyRollback 测试校验脚本失败时回滚并执行回滚脚本
+func TestVerifyRollback(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "app")
+	marker := filepath.Join(dir, "rolled-back")
+
+	pkg := buildPackage(t, models.Description{
+		Name:    "app",
+		Version: "1.0.0",
+		Files:   []models.File{{Filename: "app", Path: target}},
+		Scripts: []models.Script{
+			{Filename: "stage.sh", Type: models.ScriptPrecheck},
+			{Filename: "stage.sh", Type: models.ScriptVerify},
+			{Filename: "stage.sh", Type: models.ScriptRollback},
+		},
+	}, map[string]string{
+		"app": "app",
+		"stage.sh": "case $OTA_STAGE in\n" +
+			"verify) exit 1 ;;\n" +
+			"rollback) test -e " + target + " || touch " + marker + " ;;\n" +
+			"esac\n",
+	}, false)
+
+	c := core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state")})
+	_, err := c.Update(pkg, nil)
+
+	var installErr *core.InstallError
+	if !errors.As(err, &installErr) || !installErr.RolledBack() {
+		t.Fatalf("want rolled back InstallError, got %v", err)
+	}
+	if _, err = os.Stat(target); !os.IsNotExist(err) {
+		t.Fatalf("%s is not removed by rollback", target)
+	}
+	if _, err = os.Stat(marker); err != nil {
+		t.Fatalf("rollback script is not run after files are restored: %v", err)
+	}
+}
+
+// TestScriptInterpreter 测试指定解释器、参数和内联脚本
+func TestScriptInterpreter(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "output")
+
+	pkg := buildPackage(t, models.Description{
+		Name:    "app",
+		Version: "1.0.0",
+		Scripts: []models.Script{
+			{Filename: "args.sh", Type: models.ScriptPreinstall, Interpreter: "sh", Args: []string{"a b", "c"}},
+			{Type: models.ScriptPostinstall, Content: "#!/bin/sh\necho inline $0 >> " + output + "\n"},
+		},
+	}, map[string]string{
+		"args.sh": "echo $# \"$1\" >> " + output + "\n",
+	}, false)
+
+	c := core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state")})
+	if _, err := c.Update(pkg, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	bs, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(bs)), "\n")
+	if len(lines) != 2 || lines[0] != "2 a b" || !strings.HasPrefix(lines[1], "inline ") {
+		t.Fatalf("unexpected output:\n%s", bs)
+	}
+}
+
+// TestScriptConfine 测试以非特权用户运行脚本，并设置资源限制和独立的临时目录
+func TestScriptConfine(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("need root to switch user")
+	}
+	if _, err := user.Lookup("nobody"); err != nil {
+		t.Skip("user nobody not found")
+	}
+
+	dir := t.TempDir()
+	output := filepath.Join(dir, "output")
+	for _, d := range []string{filepath.Dir(dir), dir} {
+		if err := os.Chmod(d, 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	privateTmp := true
+	pkg := buildPackage(t, models.Description{
+		Name:    "app",
+		Version: "1.0.0",
+		Scripts: []models.Script{{
+			Filename:   "id.sh",
+			Type:       models.ScriptPreinstall,
+			User:       "nobody",
+			Limits:     &models.Limits{Files: 32},
+			PrivateTmp: &privateTmp,
+		}, {
+			Type:    models.ScriptPostinstall,
+			User:    "nobody",
+			Content: "id -un >> " + output + "\n",
+		}},
+	}, map[string]string{
+		"id.sh": "echo $(id -un) $(ulimit -n) > " + output + "\ntouch $TMPDIR/ok && echo tmp >> " + output + "\n",
+	}, false)
+
+	c := core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state")})
+	if _, err := c.Update(pkg, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	bs, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != "nobody 32\ntmp\nnobody\n" {
+		t.Fatalf("unexpected output:\n%s", bs)
+	}
+}
+
+// TestScriptLog 测试脚本输出写入升级日志
+func TestScriptLog(t *testing.T) {
+	dir := t.TempDir()
+
+	c := core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state"), ScriptLogSize: 4096})
+	for _, content := range []string{
+		"echo hello\necho oops >&2\n",
+		"i=0\nwhile [ $i -lt 1000 ]; do echo line $i; i=$((i+1)); done\n",
+	} {
+		pkg := buildPackage(t, models.Description{
+			Name:    "app",
+			Version: "1.0.0",
+			Scripts: []models.Script{{Filename: "out.sh", Type: models.ScriptPreinstall}},
+		}, map[string]string{"out.sh": content}, false)
+		if _, err := c.Update(pkg, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		output, err := c.Log("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(content, "hello") {
+			for _, s := range []string{"[preinstall out.sh stdout] hello\n", "[preinstall out.sh stderr] oops\n"} {
+				if !strings.Contains(output, s) {
+					t.Fatalf("log does not contain %q:\n%s", s, output)
+				}
+			}
+		} else if len(output) > 4096 || !strings.Contains(output, "output truncated") {
+			t.Fatalf("log is not truncated, size %d", len(output))
+		}
+	}
+
+	if _, err := c.Log("../history"); err == nil {
+		t.Fatal("invalid update id is accepted")
+	}
+}
+
+// TestScriptLongLine 测试脚本输出不换行的长内容时，错误信息和升级记录的长度受限
+func TestScriptLongLine(t *testing.T) {
+	dir := t.TempDir()
+	stateDir := filepath.Join(dir, "state")
+
+	c := core.NewCore(&config.Config{StateDir: stateDir})
+	pkg := buildPackage(t, models.Description{
+		Name:    "app",
+		Version: "1.0.0",
+		Scripts: []models.Script{{Filename: "long.sh", Type: models.ScriptPreinstall}},
+	}, map[string]string{"long.sh": "head -c 1000000 /dev/zero | tr '\\0' x\nexit 1\n"}, false)
+	h, err := c.Update(pkg, nil)
+	if err == nil {
+		t.Fatal("update should fail")
+	}
+	if len(err.Error()) > 64<<10 || len(h.Message) > 64<<10 {
+		t.Fatalf("error message is not limited: %d bytes", len(err.Error()))
+	}
+
+	// 之后的升级记录仍然可以读取
+	pkg = buildPackage(t, models.Description{
+		Name:    "app",
+		Version: "1.0.0",
+		Scripts: []models.Script{{Filename: "ok.sh", Type: models.ScriptPreinstall}},
+	}, map[string]string{"ok.sh": "echo done\n"}, false)
+	if _, err = c.Update(pkg, nil); err != nil {
+		t.Fatal(err)
+	}
+	output, err := c.Log("")
+	if err != nil || !strings.Contains(output, "done") {
+		t.Fatalf("log of the latest update is %q, %v", output, err)
+	}
+}
@@ -0,0 +1,148 @@
+package test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/ruixiaoedu/ota/config"
+	"github.com/ruixiaoedu/ota/core"
+	"github.com/ruixiaoedu/ota/models"
+	"github.com/ruixiaoedu/ota/utils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRequireSignature 测试设置公钥后拒绝未签名的升级包
+func TestRequireSignature(t *testing.T) {
+	dir := t.TempDir()
+	des := models.Description{Name: "app", Version: "1.0", Files: []models.File{{Filename: "app", Path: filepath.Join(dir, "app")}}}
+	payload := map[string]string{"app": "binary"}
+
+	c := core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state"), Keyfile: "public.pem"})
+	if _, err := c.Update(buildPackage(t, des, payload, false), nil); err == nil || !strings.Contains(err.Error(), "signature is required") {
+		t.Fatalf("unsigned package is accepted: %v", err)
+	}
+	if _, err := c.Update(buildPackage(t, des, payload, true), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// 签名的描述文件必须包含文件的sha256
+	unhashed := des
+	unhashed.Files = []models.File{{Filename: "app", Path: filepath.Join(dir, "app"), Sha256: ""}}
+	prv, err := utils.ParsePrivateKey([]byte(privateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := func(data []byte) string {
+		sig, _ := utils.SignWithSha256(data, prv)
+		return sig
+	}
+	if _, err = c.Update(buildSignedPackage(t, unhashed, payload, signer, nil), nil); err == nil || !strings.Contains(err.Error(), "sha256 is required") {
+		t.Fatalf("file without sha256 is accepted: %v", err)
+	}
+
+	// 旧格式的升级包中不能有描述文件之外的文件
+	c = core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state"), Keyfile: "public.pem", LegacyLayout: true})
+	extra := map[string]string{"app": "binary", "extra": "unsigned"}
+	if _, err := c.Update(buildPackage(t, des, extra, true), nil); err == nil || !strings.Contains(err.Error(), "not listed") {
+		t.Fatalf("unlisted file is accepted: %v", err)
+	}
+
+	// 显式关闭后接受未签名的升级包
+	off := false
+	c = core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state"), Keyfile: "public.pem", RequireSignature: &off})
+	if _, err := c.Update(buildPackage(t, des, payload, false), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestTrustedKeys 测试多个受信任公钥和签名策略
+func TestTrustedKeys(t *testing.T) {
+	dir := t.TempDir()
+	keysDir := filepath.Join(dir, "keys")
+	if err := os.MkdirAll(keysDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// vendor和operator为有效公钥，old已过期
+	prvs := make(map[string]*rsa.PrivateKey)
+	for id, headers := range map[string]map[string]string{
+		"vendor":   nil,
+		"operator": {"Expires": time.Now().Add(time.Hour).Format(time.RFC3339)},
+		"old":      {"Expires": time.Now().Add(-time.Hour).Format(time.RFC3339)},
+	} {
+		prv, err := rsa.GenerateKey(rand.Reader, 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := x509.MarshalPKIXPublicKey(&prv.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pemBytes := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Headers: headers, Bytes: der})
+		if err = ioutil.WriteFile(filepath.Join(keysDir, id+".pem"), pemBytes, 0644); err != nil {
+			t.Fatal(err)
+		}
+		prvs[id] = prv
+	}
+	signer := func(ids ...string) func(data []byte) string {
+		return func(data []byte) string {
+			var lines []string
+			for _, id := range ids {
+				sig, err := utils.SignWithSha256(data, prvs[id])
+				if err != nil {
+					t.Fatal(err)
+				}
+				lines = append(lines, id+" "+sig)
+			}
+			return strings.Join(lines, "\n")
+		}
+	}
+
+	des := models.Description{Name: "app", Version: "1.0", Files: []models.File{{Filename: "app", Path: filepath.Join(dir, "app")}}}
+	des.Files[0].Sha256, _ = utils.Sha256FromReader(strings.NewReader("binary"))
+	payload := map[string]string{"app": "binary"}
+	c := core.NewCore(&config.Config{
+		StateDir:        filepath.Join(dir, "state"),
+		KeysDir:         keysDir,
+		SignaturePolicy: []string{"1:vendor", "1:operator,old"},
+	})
+
+	for _, tc := range []struct {
+		ids  []string
+		want string
+	}{
+		{ids: []string{"vendor"}, want: "operator,old"},
+		{ids: []string{"vendor", "old"}, want: "operator,old"},
+		{ids: []string{"operator", "operator"}, want: "vendor"},
+		{ids: []string{"old"}, want: "sign is not right"},
+	} {
+		_, err := c.Update(buildSignedPackage(t, des, payload, signer(tc.ids...), nil), nil)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%v: want error containing %q, got %v", tc.ids, tc.want, err)
+		}
+	}
+	if _, err := c.Update(buildSignedPackage(t, des, payload, signer("operator", "vendor"), nil), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSignaturePolicyConfig 测试从配置文件读取多条签名策略
+func TestSignaturePolicyConfig(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "ota.ini")
+	if err := ioutil.WriteFile(filename, []byte("signature_policy = 1:vendor|1:operator,old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.NewConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.SignaturePolicy) != 2 || cfg.SignaturePolicy[0] != "1:vendor" || cfg.SignaturePolicy[1] != "1:operator,old" {
+		t.Fatalf("signature policy is %q", cfg.SignaturePolicy)
+	}
+}
@@ -12,13 +12,14 @@ import (
 func TestUpdate(t *testing.T) {
 
 	core := core.NewCore(&config.Config{
-		Keyfile: "",
+		Keyfile:  "",
+		StateDir: t.TempDir(),
 	})
 
 	f, _ := os.Open("ota.tar.gz")
 	defer f.Close()
 
-	err := core.Update(f)
+	_, err := core.Update(f, nil)
 	fmt.Println(err)
 
 }
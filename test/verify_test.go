@@ -0,0 +1,176 @@
+package test
+
+import (
+	"github.com/ruixiaoedu/ota/config"
+	"github.com/ruixiaoedu/ota/core"
+	"github.com/ruixiaoedu/ota/models"
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// TestVerifyRepair 测试校验和修复已安装的文件
+func TestVerifyRepair(t *testing.T) {
+	dir := t.TempDir()
+	app := filepath.Join(dir, "app")
+	bin := filepath.Join(app, "app")
+	conf := filepath.Join(app, "app.conf")
+	link := filepath.Join(app, "current")
+	extra := filepath.Join(app, "extra")
+
+	c := core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state")})
+	pkg := buildPackage(t, models.Description{
+		Name:    "app",
+		Version: "1.0",
+		Files: []models.File{
+			{Path: app, Type: models.FileDir, Mirror: true},
+			{Filename: "app", Path: bin, Mode: "0755"},
+			{Filename: "app.conf", Path: conf, Conffile: true},
+			{Path: link, Type: models.FileSymlink, Target: "app"},
+		},
+	}, map[string]string{"app": "binary", "app.conf": "conf"}, false)
+	if _, err := c.Update(pkg, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := c.Verify("app", "", false)
+	if err != nil || len(results) != 0 {
+		t.Fatalf("fresh install has problems: %v, %v", results, err)
+	}
+
+	// 模拟损坏和手工修改
+	if err = ioutil.WriteFile(bin, []byte("patched"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chmod(bin, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(conf, []byte("local"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Remove(link); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(extra, []byte("extra"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	summary := func(results []models.VerifyResult) string {
+		var lines []string
+		for _, r := range results {
+			lines = append(lines, r.Status+" "+filepath.Base(r.Path)+" "+strings.Join(r.Details, ","))
+		}
+		sort.Strings(lines)
+		return strings.Join(lines, "; ")
+	}
+
+	want := "extra extra ; missing current ; modified app sha256,mode; modified app.conf sha256"
+	if results, err = c.Verify("", "", false); err != nil || summary(results) != want {
+		t.Fatalf("results are %q, %v, want %q", summary(results), err, want)
+	}
+
+	if results, err = c.Verify("app", "", true); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if r.Repaired == r.Conffile {
+			t.Fatalf("%s repaired: %v", r.Path, r.Repaired)
+		}
+	}
+
+	// 修复后只剩本地修改过的配置文件
+	if results, err = c.Verify("app", "", false); err != nil || summary(results) != "modified app.conf sha256" {
+		t.Fatalf("results after repair are %q, %v", summary(results), err)
+	}
+	if bs, _ := ioutil.ReadFile(bin); string(bs) != "binary" {
+		t.Fatalf("repaired content is %q", bs)
+	}
+	if _, err = c.Verify("missing", "", false); err == nil {
+		t.Fatal("verifying a package that is not installed should fail")
+	}
+}
+
+// TestRepairCorruptedCache 测试缓存内容损坏时不用于修复
+func TestRepairCorruptedCache(t *testing.T) {
+	dir := t.TempDir()
+	stateDir := filepath.Join(dir, "state")
+	bin := filepath.Join(dir, "app")
+
+	c := core.NewCore(&config.Config{StateDir: stateDir})
+	pkg := buildPackage(t, models.Description{
+		Name:    "app",
+		Version: "1.0",
+		Files:   []models.File{{Filename: "app", Path: bin}},
+	}, map[string]string{"app": "binary"}, false)
+	if _, err := c.Update(pkg, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	objects, err := filepath.Glob(filepath.Join(stateDir, "objects", "*"))
+	if err != nil || len(objects) != 1 {
+		t.Fatalf("cached objects are %v, %v", objects, err)
+	}
+	if err = os.Chmod(objects[0], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(objects[0], []byte("evil"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(bin, []byte("damaged"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := c.Verify("app", "", true)
+	if err != nil || len(results) != 1 || results[0].Repaired {
+		t.Fatalf("repair results are %+v, %v", results, err)
+	}
+	if bs, _ := ioutil.ReadFile(bin); string(bs) != "damaged" {
+		t.Fatalf("%s is %q after repair", bin, bs)
+	}
+	if _, err = os.Stat(objects[0]); !os.IsNotExist(err) {
+		t.Fatalf("corrupted object is not removed: %v", err)
+	}
+}
+
+// TestVerifyXattrs 测试校验和修复声明的扩展属性
+func TestVerifyXattrs(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "app")
+	if err := ioutil.WriteFile(bin, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Setxattr(bin, "user.probe", []byte("x"), 0); err != nil {
+		t.Skip("xattrs are not supported:", err)
+	}
+	os.Remove(bin)
+
+	c := core.NewCore(&config.Config{StateDir: filepath.Join(dir, "state")})
+	pkg := buildPackage(t, models.Description{
+		Name:    "app",
+		Version: "1.0",
+		Files:   []models.File{{Filename: "app", Path: bin, Xattrs: map[string]string{"user.test": "value"}}},
+	}, map[string]string{"app": "binary"}, false)
+	if _, err := c.Update(pkg, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := unix.Removexattr(bin, "user.test"); err != nil {
+		t.Fatal(err)
+	}
+	results, err := c.Verify("app", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Details[0] != "xattrs" || !results[0].Repaired {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	buf := make([]byte, 16)
+	n, err := unix.Getxattr(bin, "user.test", buf)
+	if err != nil || string(buf[:n]) != "value" {
+		t.Fatalf("xattr is not repaired: %q %v", buf[:n], err)
+	}
+}
@@ -10,6 +10,13 @@ It is generated from these files:
 It has these top-level messages:
 	UpdateRequest
 	UpdateReply
+	RemoveRequest
+	RemoveReply
+	VerifyRequest
+	VerifyResult
+	VerifyReply
+	LogRequest
+	LogReply
 */
 package pb
 
@@ -34,7 +41,9 @@ var _ = math.Inf
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
 type UpdateRequest struct {
-	Url string `protobuf:"bytes,1,opt,name=url" json:"url,omitempty"`
+	Url   string `protobuf:"bytes,1,opt,name=url" json:"url,omitempty"`
+	Root  string `protobuf:"bytes,2,opt,name=root" json:"root,omitempty"`
+	Force bool   `protobuf:"varint,3,opt,name=force" json:"force,omitempty"`
 }
 
 func (m *UpdateRequest) Reset()                    { *m = UpdateRequest{} }
@@ -49,9 +58,25 @@ func (m *UpdateRequest) GetUrl() string {
 	return ""
 }
 
+func (m *UpdateRequest) GetRoot() string {
+	if m != nil {
+		return m.Root
+	}
+	return ""
+}
+
+func (m *UpdateRequest) GetForce() bool {
+	if m != nil {
+		return m.Force
+	}
+	return false
+}
+
 type UpdateReply struct {
-	Ok      bool   `protobuf:"varint,1,opt,name=ok" json:"ok,omitempty"`
-	Message string `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
+	Ok       bool     `protobuf:"varint,1,opt,name=ok" json:"ok,omitempty"`
+	Message  string   `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
+	Id       string   `protobuf:"bytes,3,opt,name=id" json:"id,omitempty"`
+	Warnings []string `protobuf:"bytes,4,rep,name=warnings" json:"warnings,omitempty"`
 }
 
 func (m *UpdateReply) Reset()                    { *m = UpdateReply{} }
@@ -73,9 +98,262 @@ func (m *UpdateReply) GetMessage() string {
 	return ""
 }
 
+func (m *UpdateReply) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *UpdateReply) GetWarnings() []string {
+	if m != nil {
+		return m.Warnings
+	}
+	return nil
+}
+
+type RemoveRequest struct {
+	Name string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Root string `protobuf:"bytes,2,opt,name=root" json:"root,omitempty"`
+}
+
+func (m *RemoveRequest) Reset()                    { *m = RemoveRequest{} }
+func (m *RemoveRequest) String() string            { return proto.CompactTextString(m) }
+func (*RemoveRequest) ProtoMessage()               {}
+func (*RemoveRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *RemoveRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *RemoveRequest) GetRoot() string {
+	if m != nil {
+		return m.Root
+	}
+	return ""
+}
+
+type RemoveReply struct {
+	Ok       bool     `protobuf:"varint,1,opt,name=ok" json:"ok,omitempty"`
+	Message  string   `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
+	Id       string   `protobuf:"bytes,3,opt,name=id" json:"id,omitempty"`
+	Warnings []string `protobuf:"bytes,4,rep,name=warnings" json:"warnings,omitempty"`
+}
+
+func (m *RemoveReply) Reset()                    { *m = RemoveReply{} }
+func (m *RemoveReply) String() string            { return proto.CompactTextString(m) }
+func (*RemoveReply) ProtoMessage()               {}
+func (*RemoveReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *RemoveReply) GetOk() bool {
+	if m != nil {
+		return m.Ok
+	}
+	return false
+}
+
+func (m *RemoveReply) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+func (m *RemoveReply) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *RemoveReply) GetWarnings() []string {
+	if m != nil {
+		return m.Warnings
+	}
+	return nil
+}
+
+type VerifyRequest struct {
+	Name   string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Root   string `protobuf:"bytes,2,opt,name=root" json:"root,omitempty"`
+	Repair bool   `protobuf:"varint,3,opt,name=repair" json:"repair,omitempty"`
+}
+
+func (m *VerifyRequest) Reset()                    { *m = VerifyRequest{} }
+func (m *VerifyRequest) String() string            { return proto.CompactTextString(m) }
+func (*VerifyRequest) ProtoMessage()               {}
+func (*VerifyRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *VerifyRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *VerifyRequest) GetRoot() string {
+	if m != nil {
+		return m.Root
+	}
+	return ""
+}
+
+func (m *VerifyRequest) GetRepair() bool {
+	if m != nil {
+		return m.Repair
+	}
+	return false
+}
+
+type VerifyResult struct {
+	Name     string   `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Path     string   `protobuf:"bytes,2,opt,name=path" json:"path,omitempty"`
+	Status   string   `protobuf:"bytes,3,opt,name=status" json:"status,omitempty"`
+	Details  []string `protobuf:"bytes,4,rep,name=details" json:"details,omitempty"`
+	Conffile bool     `protobuf:"varint,5,opt,name=conffile" json:"conffile,omitempty"`
+	Repaired bool     `protobuf:"varint,6,opt,name=repaired" json:"repaired,omitempty"`
+}
+
+func (m *VerifyResult) Reset()                    { *m = VerifyResult{} }
+func (m *VerifyResult) String() string            { return proto.CompactTextString(m) }
+func (*VerifyResult) ProtoMessage()               {}
+func (*VerifyResult) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+func (m *VerifyResult) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *VerifyResult) GetPath() string {
+	if m != nil {
+		return m.Path
+	}
+	return ""
+}
+
+func (m *VerifyResult) GetStatus() string {
+	if m != nil {
+		return m.Status
+	}
+	return ""
+}
+
+func (m *VerifyResult) GetDetails() []string {
+	if m != nil {
+		return m.Details
+	}
+	return nil
+}
+
+func (m *VerifyResult) GetConffile() bool {
+	if m != nil {
+		return m.Conffile
+	}
+	return false
+}
+
+func (m *VerifyResult) GetRepaired() bool {
+	if m != nil {
+		return m.Repaired
+	}
+	return false
+}
+
+type VerifyReply struct {
+	Ok      bool            `protobuf:"varint,1,opt,name=ok" json:"ok,omitempty"`
+	Message string          `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
+	Results []*VerifyResult `protobuf:"bytes,3,rep,name=results" json:"results,omitempty"`
+}
+
+func (m *VerifyReply) Reset()                    { *m = VerifyReply{} }
+func (m *VerifyReply) String() string            { return proto.CompactTextString(m) }
+func (*VerifyReply) ProtoMessage()               {}
+func (*VerifyReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+func (m *VerifyReply) GetOk() bool {
+	if m != nil {
+		return m.Ok
+	}
+	return false
+}
+
+func (m *VerifyReply) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+func (m *VerifyReply) GetResults() []*VerifyResult {
+	if m != nil {
+		return m.Results
+	}
+	return nil
+}
+
+type LogRequest struct {
+	Id string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+}
+
+func (m *LogRequest) Reset()                    { *m = LogRequest{} }
+func (m *LogRequest) String() string            { return proto.CompactTextString(m) }
+func (*LogRequest) ProtoMessage()               {}
+func (*LogRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+func (m *LogRequest) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+type LogReply struct {
+	Ok      bool   `protobuf:"varint,1,opt,name=ok" json:"ok,omitempty"`
+	Message string `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
+	Content string `protobuf:"bytes,3,opt,name=content" json:"content,omitempty"`
+}
+
+func (m *LogReply) Reset()                    { *m = LogReply{} }
+func (m *LogReply) String() string            { return proto.CompactTextString(m) }
+func (*LogReply) ProtoMessage()               {}
+func (*LogReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+func (m *LogReply) GetOk() bool {
+	if m != nil {
+		return m.Ok
+	}
+	return false
+}
+
+func (m *LogReply) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+func (m *LogReply) GetContent() string {
+	if m != nil {
+		return m.Content
+	}
+	return ""
+}
+
 func init() {
 	proto.RegisterType((*UpdateRequest)(nil), "service.UpdateRequest")
 	proto.RegisterType((*UpdateReply)(nil), "service.UpdateReply")
+	proto.RegisterType((*RemoveRequest)(nil), "service.RemoveRequest")
+	proto.RegisterType((*RemoveReply)(nil), "service.RemoveReply")
+	proto.RegisterType((*VerifyRequest)(nil), "service.VerifyRequest")
+	proto.RegisterType((*VerifyResult)(nil), "service.VerifyResult")
+	proto.RegisterType((*VerifyReply)(nil), "service.VerifyReply")
+	proto.RegisterType((*LogRequest)(nil), "service.LogRequest")
+	proto.RegisterType((*LogReply)(nil), "service.LogReply")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -90,6 +368,9 @@ const _ = grpc.SupportPackageIsVersion4
 
 type OtaClient interface {
 	Update(ctx context.Context, in *UpdateRequest, opts ...grpc.CallOption) (*UpdateReply, error)
+	Remove(ctx context.Context, in *RemoveRequest, opts ...grpc.CallOption) (*RemoveReply, error)
+	Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (*VerifyReply, error)
+	Log(ctx context.Context, in *LogRequest, opts ...grpc.CallOption) (*LogReply, error)
 }
 
 type otaClient struct {
@@ -109,10 +390,40 @@ func (c *otaClient) Update(ctx context.Context, in *UpdateRequest, opts ...grpc.
 	return out, nil
 }
 
+func (c *otaClient) Remove(ctx context.Context, in *RemoveRequest, opts ...grpc.CallOption) (*RemoveReply, error) {
+	out := new(RemoveReply)
+	err := grpc.Invoke(ctx, "/service.Ota/Remove", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *otaClient) Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (*VerifyReply, error) {
+	out := new(VerifyReply)
+	err := grpc.Invoke(ctx, "/service.Ota/Verify", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *otaClient) Log(ctx context.Context, in *LogRequest, opts ...grpc.CallOption) (*LogReply, error) {
+	out := new(LogReply)
+	err := grpc.Invoke(ctx, "/service.Ota/Log", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for Ota service
 
 type OtaServer interface {
 	Update(context.Context, *UpdateRequest) (*UpdateReply, error)
+	Remove(context.Context, *RemoveRequest) (*RemoveReply, error)
+	Verify(context.Context, *VerifyRequest) (*VerifyReply, error)
+	Log(context.Context, *LogRequest) (*LogReply, error)
 }
 
 func RegisterOtaServer(s *grpc.Server, srv OtaServer) {
@@ -137,6 +448,60 @@ func _Ota_Update_Handler(srv interface{}, ctx context.Context, dec func(interfac
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Ota_Remove_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OtaServer).Remove(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/service.Ota/Remove",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OtaServer).Remove(ctx, req.(*RemoveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ota_Verify_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OtaServer).Verify(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/service.Ota/Verify",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OtaServer).Verify(ctx, req.(*VerifyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ota_Log_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LogRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OtaServer).Log(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/service.Ota/Log",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OtaServer).Log(ctx, req.(*LogRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _Ota_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "service.Ota",
 	HandlerType: (*OtaServer)(nil),
@@ -145,6 +510,18 @@ var _Ota_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Update",
 			Handler:    _Ota_Update_Handler,
 		},
+		{
+			MethodName: "Remove",
+			Handler:    _Ota_Remove_Handler,
+		},
+		{
+			MethodName: "Verify",
+			Handler:    _Ota_Verify_Handler,
+		},
+		{
+			MethodName: "Log",
+			Handler:    _Ota_Log_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "ota.proto",
@@ -153,15 +530,32 @@ var _Ota_serviceDesc = grpc.ServiceDesc{
 func init() { proto.RegisterFile("ota.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 157 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0xcc, 0x2f, 0x49, 0xd4,
-	0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0x2f, 0x4e, 0x2d, 0x2a, 0xcb, 0x4c, 0x4e, 0x55, 0x52,
-	0xe4, 0xe2, 0x0d, 0x2d, 0x48, 0x49, 0x2c, 0x49, 0x0d, 0x4a, 0x2d, 0x2c, 0x4d, 0x2d, 0x2e, 0x11,
-	0x12, 0xe0, 0x62, 0x2e, 0x2d, 0xca, 0x91, 0x60, 0x54, 0x60, 0xd4, 0xe0, 0x0c, 0x02, 0x31, 0x95,
-	0xcc, 0xb9, 0xb8, 0x61, 0x4a, 0x0a, 0x72, 0x2a, 0x85, 0xf8, 0xb8, 0x98, 0xf2, 0xb3, 0xc1, 0xf2,
-	0x1c, 0x41, 0x4c, 0xf9, 0xd9, 0x42, 0x12, 0x5c, 0xec, 0xb9, 0xa9, 0xc5, 0xc5, 0x89, 0xe9, 0xa9,
-	0x12, 0x4c, 0x60, 0x4d, 0x30, 0xae, 0x91, 0x3d, 0x17, 0xb3, 0x7f, 0x49, 0xa2, 0x90, 0x05, 0x17,
-	0x1b, 0x44, 0xbf, 0x90, 0x98, 0x1e, 0xd4, 0x5a, 0x3d, 0x14, 0x3b, 0xa5, 0x44, 0x30, 0xc4, 0x0b,
-	0x72, 0x2a, 0x95, 0x18, 0x9c, 0x58, 0xa2, 0x98, 0x0a, 0x92, 0x92, 0xd8, 0xc0, 0x4e, 0x36, 0x06,
-	0x04, 0x00, 0x00, 0xff, 0xff, 0x8a, 0xb1, 0xe3, 0x54, 0xbf, 0x00, 0x00, 0x00,
+	// 429 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x94, 0xbd, 0x8e, 0x13, 0x31,
+	0x10, 0xc7, 0xd9, 0xdd, 0x5c, 0x3e, 0x26, 0x04, 0x81, 0x09, 0x27, 0x6b, 0x45, 0x11, 0xb9, 0x4a,
+	0x95, 0x93, 0x8e, 0x02, 0x6a, 0x5a, 0x4e, 0x9c, 0x64, 0x09, 0x0a, 0x3a, 0xdf, 0xee, 0x24, 0x67,
+	0xdd, 0x66, 0xbd, 0xd8, 0x4e, 0x50, 0xde, 0x86, 0x87, 0xe3, 0x41, 0x90, 0xbf, 0x96, 0x44, 0x81,
+	0x22, 0x05, 0xdd, 0xfc, 0x67, 0x76, 0xfe, 0xfa, 0xcd, 0x78, 0x12, 0x98, 0x28, 0x2b, 0x56, 0x9d,
+	0x56, 0x56, 0x91, 0x91, 0x41, 0xbd, 0x97, 0x15, 0xb2, 0x4f, 0x30, 0xfb, 0xd2, 0xd5, 0xc2, 0x22,
+	0xc7, 0xef, 0x3b, 0x34, 0x96, 0xbc, 0x84, 0x62, 0xa7, 0x1b, 0x9a, 0x2d, 0xb2, 0xe5, 0x84, 0xbb,
+	0x90, 0x10, 0x18, 0x68, 0xa5, 0x2c, 0xcd, 0x7d, 0xca, 0xc7, 0x64, 0x0e, 0x57, 0x6b, 0xa5, 0x2b,
+	0xa4, 0xc5, 0x22, 0x5b, 0x8e, 0x79, 0x10, 0xac, 0x82, 0x69, 0x32, 0xeb, 0x9a, 0x03, 0x79, 0x01,
+	0xb9, 0x7a, 0xf2, 0x4e, 0x63, 0x9e, 0xab, 0x27, 0x42, 0x61, 0xb4, 0x45, 0x63, 0xc4, 0x06, 0xa3,
+	0x57, 0x92, 0xee, 0x4b, 0x59, 0x7b, 0xaf, 0x09, 0xcf, 0x65, 0x4d, 0x4a, 0x18, 0xff, 0x10, 0xba,
+	0x95, 0xed, 0xc6, 0xd0, 0xc1, 0xa2, 0x58, 0x4e, 0x78, 0xaf, 0xd9, 0x7b, 0x98, 0x71, 0xdc, 0xaa,
+	0x7d, 0x4f, 0x4c, 0x60, 0xd0, 0x8a, 0x2d, 0x46, 0x64, 0x1f, 0xff, 0x8d, 0xd9, 0xd1, 0xa5, 0xc6,
+	0xff, 0x47, 0x77, 0x0f, 0xb3, 0xaf, 0xa8, 0xe5, 0xfa, 0x70, 0x21, 0x1d, 0xb9, 0x86, 0xa1, 0xc6,
+	0x4e, 0x48, 0x1d, 0x57, 0x1a, 0x15, 0xfb, 0x99, 0xc1, 0xf3, 0xe4, 0x68, 0x76, 0xcd, 0x3f, 0x0d,
+	0x3b, 0x61, 0x1f, 0x93, 0xa1, 0x8b, 0x9d, 0xa1, 0xb1, 0xc2, 0xee, 0x4c, 0x24, 0x8f, 0xca, 0xcd,
+	0x59, 0xa3, 0x15, 0xb2, 0x49, 0xf0, 0x49, 0xba, 0xb9, 0x2a, 0xd5, 0xae, 0xd7, 0xb2, 0x41, 0x7a,
+	0xe5, 0x21, 0x7a, 0xed, 0x6a, 0x01, 0x08, 0x6b, 0x3a, 0x0c, 0xb5, 0xa4, 0xd9, 0x23, 0x4c, 0x13,
+	0xe1, 0x65, 0x8b, 0xbd, 0x81, 0x91, 0xf6, 0x43, 0x39, 0xc6, 0x62, 0x39, 0xbd, 0x7d, 0xb3, 0x8a,
+	0x77, 0xb9, 0x3a, 0x1e, 0x99, 0xa7, 0xaf, 0xd8, 0x5b, 0x80, 0x3b, 0xb5, 0x49, 0xab, 0x0d, 0xef,
+	0x92, 0xa5, 0x77, 0x61, 0x9f, 0x61, 0xec, 0xab, 0x97, 0x41, 0x50, 0x18, 0x55, 0xaa, 0xb5, 0xd8,
+	0xda, 0xb8, 0xa8, 0x24, 0x6f, 0x7f, 0x65, 0x50, 0xdc, 0x5b, 0x41, 0x3e, 0xc0, 0x30, 0x9c, 0x35,
+	0xb9, 0xee, 0xf9, 0x4e, 0x7e, 0x34, 0xe5, 0xfc, 0x2c, 0xdf, 0x35, 0x07, 0xf6, 0xcc, 0x75, 0x86,
+	0x93, 0x3b, 0xea, 0x3c, 0x39, 0xde, 0x72, 0x7e, 0x96, 0xef, 0x3b, 0xc3, 0x0a, 0x8e, 0x3a, 0x4f,
+	0x0e, 0xab, 0x9c, 0x9f, 0xe5, 0x43, 0xe7, 0x0d, 0x14, 0x77, 0x6a, 0x43, 0x5e, 0xf7, 0xe5, 0x3f,
+	0x1b, 0x2b, 0x5f, 0x9d, 0x26, 0x7d, 0xc3, 0xc7, 0xc1, 0xb7, 0xbc, 0x7b, 0x78, 0x18, 0xfa, 0x3f,
+	0x86, 0x77, 0xbf, 0x07, 0x00, 0x73, 0x66, 0x6a, 0xd4, 0x25, 0x04, 0x00, 0x00,
 }
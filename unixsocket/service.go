@@ -2,6 +2,7 @@ package unixsocket
 
 import (
 	"github.com/ruixiaoedu/ota/interfaces"
+	"github.com/ruixiaoedu/ota/models"
 	"github.com/ruixiaoedu/ota/unixsocket/pb"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
@@ -33,21 +34,16 @@ func (s *Service) Update(ctx context.Context, up *pb.UpdateRequest) (*pb.UpdateR
 		}, nil
 	}
 
+	var (
+		h    *models.History
+		err  error
+		opts = &models.UpdateOptions{Root: up.Root, Force: up.Force}
+	)
 	switch us[0] {
 	case "file":
-		if err := s.core.UpdateFromLocalFile(us[1]); err != nil {
-			return &pb.UpdateReply{
-				Ok:      false,
-				Message: err.Error(),
-			}, nil
-		}
+		h, err = s.core.UpdateFromLocalFile(us[1], opts)
 	case "http", "https":
-		if err := s.core.UpdateFromUrl(up.Url); err != nil {
-			return &pb.UpdateReply{
-				Ok:      false,
-				Message: err.Error(),
-			}, nil
-		}
+		h, err = s.core.UpdateFromUrl(up.Url, opts)
 	default:
 		return &pb.UpdateReply{
 			Ok:      false,
@@ -55,9 +51,73 @@ func (s *Service) Update(ctx context.Context, up *pb.UpdateRequest) (*pb.UpdateR
 		}, nil
 	}
 
-	return &pb.UpdateReply{
+	reply := &pb.UpdateReply{
+		Ok:      err == nil,
+		Message: "OK",
+	}
+	if err != nil {
+		reply.Message = err.Error()
+	}
+	if h != nil {
+		reply.Id = h.Id
+		reply.Warnings = h.Warnings
+	}
+	return reply, nil
+}
+
+func (s *Service) Remove(ctx context.Context, req *pb.RemoveRequest) (*pb.RemoveReply, error) {
+	h, err := s.core.Remove(req.Name, req.Root)
+
+	reply := &pb.RemoveReply{
+		Ok:      err == nil,
+		Message: "OK",
+	}
+	if err != nil {
+		reply.Message = err.Error()
+	}
+	if h != nil {
+		reply.Id = h.Id
+		reply.Warnings = h.Warnings
+	}
+	return reply, nil
+}
+
+func (s *Service) Verify(ctx context.Context, req *pb.VerifyRequest) (*pb.VerifyReply, error) {
+	results, err := s.core.Verify(req.Name, req.Root, req.Repair)
+
+	reply := &pb.VerifyReply{
+		Ok:      err == nil,
+		Message: "OK",
+	}
+	if err != nil {
+		reply.Message = err.Error()
+	}
+	for _, r := range results {
+		reply.Results = append(reply.Results, &pb.VerifyResult{
+			Name:     r.Package,
+			Path:     r.Path,
+			Status:   r.Status,
+			Details:  r.Details,
+			Conffile: r.Conffile,
+			Repaired: r.Repaired,
+		})
+	}
+	return reply, nil
+}
+
+func (s *Service) Log(ctx context.Context, req *pb.LogRequest) (*pb.LogReply, error) {
+	content, err := s.core.Log(req.Id)
+	if err != nil {
+		return &pb.LogReply{
+			Ok:      false,
+			Message: err.Error(),
+		}, nil
+	}
+
+	return &pb.LogReply{
 		Ok:      true,
 		Message: "OK",
+		Content: content,
 	}, nil
 }
 
@@ -12,10 +12,14 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"golang.org/x/sys/unix"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 )
 
 // FileExist 文件是否存在
@@ -39,6 +43,266 @@ func CreateFile(name string) (*os.File, error) {
 	return os.Create(name)
 }
 
+// WriteFileAtomic 原子地写入文件
+// 内容先写入同目录下的临时文件并落盘，再重命名覆盖目标文件，最后同步父目录，
+// 读取方只会看到旧内容或新内容。目标文件已存在时沿用其所有者、权限（包括setuid等位）
+// 和扩展属性，否则为0644；prepare不为空时，在落盘前对临时文件调用，可用于覆盖这些属性
+func WriteFileAtomic(name string, reader io.Reader, prepare func(f *os.File) error) error {
+	dir, base := filepath.Split(name)
+	if dir == "" {
+		dir = "."
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0644)
+	fi, err := os.Stat(name)
+	if err == nil {
+		mode = fi.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	} else {
+		fi = nil
+	}
+
+	temp, err := ioutil.TempFile(dir, "."+base+".ota-")
+	if err != nil {
+		return err
+	}
+	tempName := temp.Name()
+	committed := false
+	defer func() {
+		if !committed {
+			temp.Close()
+			os.Remove(tempName)
+		}
+	}()
+
+	if _, err = io.Copy(temp, reader); err != nil {
+		return err
+	}
+	// 先修改所有者再修改权限，chown会清除setuid位
+	if fi != nil {
+		if err = chownLike(temp, fi); err != nil {
+			return err
+		}
+	}
+	if err = temp.Chmod(mode); err != nil {
+		return err
+	}
+	if fi != nil {
+		if err = copyXattrs(name, temp); err != nil {
+			return err
+		}
+	}
+	if prepare != nil {
+		if err = prepare(temp); err != nil {
+			return err
+		}
+	}
+	if err = temp.Sync(); err != nil {
+		return err
+	}
+	if err = temp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tempName, name); err != nil {
+		return err
+	}
+	committed = true
+
+	return SyncDir(dir)
+}
+
+// chownLike 将f的所有者修改为与fi相同，已相同时不修改
+func chownLike(f *os.File, fi os.FileInfo) error {
+	want, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	current, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if st, ok := current.Sys().(*syscall.Stat_t); ok && st.Uid == want.Uid && st.Gid == want.Gid {
+		return nil
+	}
+	return f.Chown(int(want.Uid), int(want.Gid))
+}
+
+// copyXattrs 将文件的扩展属性复制到f上，文件系统不支持时忽略
+// security.capability赋予的是原文件内容的权限，不复制到新内容上
+func copyXattrs(name string, f *os.File) error {
+	size, err := unix.Listxattr(name, nil)
+	if err != nil || size == 0 {
+		if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP) {
+			err = nil
+		}
+		return err
+	}
+	buf := make([]byte, size)
+	if size, err = unix.Listxattr(name, buf); err != nil {
+		return err
+	}
+
+	for _, attr := range strings.Split(string(buf[:size]), "\x00") {
+		if attr == "" || attr == "security.capability" {
+			continue
+		}
+		size, err := unix.Getxattr(name, attr, nil)
+		if err != nil {
+			return err
+		}
+		value := make([]byte, size)
+		if size, err = unix.Getxattr(name, attr, value); err != nil {
+			return err
+		}
+		if err = unix.Fsetxattr(int(f.Fd()), attr, value[:size], 0); err != nil {
+			return fmt.Errorf("copy xattr %s of %s fail: %v", attr, name, err)
+		}
+	}
+	return nil
+}
+
+// SymlinkAtomic 原子地创建或替换符号链接
+func SymlinkAtomic(target, name string) error {
+	return replaceAtomic(name, func(temp string) error {
+		return os.Symlink(target, temp)
+	})
+}
+
+// LinkAtomic 原子地创建或替换硬链接
+func LinkAtomic(target, name string) error {
+	return replaceAtomic(name, func(temp string) error {
+		return os.Link(target, temp)
+	})
+}
+
+// replaceAtomic 在同目录下用create创建临时路径，再重命名覆盖name
+func replaceAtomic(name string, create func(temp string) error) error {
+	dir, base := filepath.Split(name)
+	if dir == "" {
+		dir = "."
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	var temp string
+	for i := 0; ; i++ {
+		temp = filepath.Join(dir, fmt.Sprintf(".%s.ota-%d-%d", base, time.Now().UnixNano(), i))
+		err := create(temp)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) || i >= 100 {
+			return err
+		}
+	}
+
+	if err := os.Rename(temp, name); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	// 两者为同一文件的硬链接时重命名不做任何操作，临时路径仍然存在
+	os.Remove(temp)
+
+	return SyncDir(dir)
+}
+
+// SyncDir 将目录项落盘
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// CopyFile 复制文件，保留文件权限
+func CopyFile(src, dst string) error {
+	source, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	fi, err := source.Stat()
+	if err != nil {
+		return err
+	}
+
+	destination, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(destination, source); err != nil {
+		destination.Close()
+		return err
+	}
+	if err = destination.Sync(); err != nil {
+		destination.Close()
+		return err
+	}
+	return destination.Close()
+}
+
+// maxSymlinks 解析路径时最多跟随的符号链接数
+const maxSymlinks = 255
+
+// ResolveInRoot 将name解析为root下的路径，name按相对root的路径处理
+// 上级目录中的..和符号链接都在root内解析，绝对路径的链接目标相对root，不会逃逸到root之外；
+// 最后一级不跟随符号链接，以便替换或删除链接本身
+func ResolveInRoot(root, name string) (string, error) {
+	root = filepath.Clean(root)
+
+	var resolved string // 已解析的部分，相对root且不含..
+	parts := strings.Split(name, "/")
+	for links := 0; len(parts) > 0; {
+		part := parts[0]
+		parts = parts[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if resolved = filepath.Dir(resolved); resolved == "." {
+				resolved = ""
+			}
+			continue
+		}
+
+		next := filepath.Join(resolved, part)
+		if len(parts) == 0 {
+			resolved = next
+			break
+		}
+
+		fi, err := os.Lstat(filepath.Join(root, next))
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		if links++; links > maxSymlinks {
+			return "", fmt.Errorf("%s: too many levels of symbolic links", name)
+		}
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = ""
+		}
+		parts = append(strings.Split(target, "/"), parts...)
+	}
+
+	if resolved == "" {
+		return "", fmt.Errorf("%s: path resolves to the root %s", name, root)
+	}
+	return filepath.Join(root, resolved), nil
+}
+
 // ParsePrivateKey 解析私钥
 func ParsePrivateKey(key []byte) (*rsa.PrivateKey, error) {
 	// 解析PEM文件